@@ -1,73 +1,56 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"flatfinder/internal/flatfinder"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/joho/godotenv"
 )
 
-func init() {
-	// Load .env
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Cannot load .env file in current directory")
-	}
-}
-
 func main() {
-	// Load env vars and validate
-	flatfinder.Conf = flatfinder.LocalConfig{}
-
-	// Load webhook
-	flatfinder.Conf.DiscordWebhook = os.Getenv("DISCORD_WEBHOOK")
-	if flatfinder.Conf.DiscordWebhook == "" {
-		log.Fatal("DISCORD_WEBHOOK not set")
-	}
-	flatfinder.Conf.DiscordTag = os.Getenv("DISCORD_TAG")
-
-	// Load Google stuff
-	flatfinder.Conf.GoogleApiToken = os.Getenv("GOOGLE_API_KEY")
-	if flatfinder.Conf.GoogleApiToken == "" {
-		log.Print("GOOGLE_API_KEY not set. Not using map logicc")
-	}
-	flatfinder.Conf.GoogleLocation1 = os.Getenv("GOOGLE_LOCATION_1")
-	flatfinder.Conf.GoogleLocation2 = os.Getenv("GOOGLE_LOCATION_2")
-
-	// Load trademe config
-	flatfinder.Conf.TradeMeKey = os.Getenv("TRADEME_API_KEY")
-	flatfinder.Conf.TradeMeSecret = os.Getenv("TRADEME_API_SECRET")
-	if flatfinder.Conf.TradeMeKey == "" || flatfinder.Conf.TradeMeSecret == "" {
-		log.Fatal("TRADEME_API_KEY or TRADEME_API_SECRET not set")
-	}
-
-	// Load filterse
-	flatfinder.Conf.Suburbs = os.Getenv("SUBURBS")
-	if flatfinder.Conf.Suburbs == "" {
-		log.Fatal("SUBURBS not set")
-	}
-
-	flatfinder.Conf.BedroomsMin = os.Getenv("BEDROOMS_MIN")
-	if flatfinder.Conf.BedroomsMin == "" {
-		log.Fatal("BEDROOMS_MIN not set")
-	}
-
-	flatfinder.Conf.BedroomsMax = os.Getenv("BEDROOMS_MAX")
-	if flatfinder.Conf.BedroomsMax == "" {
-		log.Fatal("BEDROOMS_MAX not set")
+	configPath := flag.String("config", "", "Path to JSON config file (default config.json in the working directory or $XDG_CONFIG_HOME/flatfinder)")
+	stateDir := flag.String("state-dir", "", "Directory for the listing database (default $XDG_STATE_HOME/flatfinder)")
+	cacheDir := flag.String("cache-dir", "", "Directory for cached Google and broadband lookups (default $XDG_CACHE_HOME/flatfinder)")
+	envFile := flag.String("env-file", "", "Path to .env file (default .env in the working directory or $XDG_CONFIG_HOME/flatfinder)")
+	trademeAuth := flag.Bool("trademe-auth", false, "Authorize with a Trade Me member account, save the token to the state dir and exit")
+	flag.Parse()
+
+	// Load .env, optional now config can come from a file
+	if *envFile == "" {
+		*envFile = flatfinder.FindEnvFile()
+	}
+	if *envFile != "" {
+		err := godotenv.Load(*envFile)
+		if err != nil {
+			log.Fatalf("Cannot load %s: %s", *envFile, err)
+		}
+		log.Printf("Loaded env from %s", *envFile)
+	}
+
+	// Load config and validate
+	var err error
+	flatfinder.Conf, err = flatfinder.LoadConfig(*configPath, *stateDir, *cacheDir)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	flatfinder.Conf.PriceMax = os.Getenv("PRICE_MAX")
-	if flatfinder.Conf.PriceMax == "" {
-		log.Fatal("PRICE_MAX not set")
-	}
+	// Stop cleanly on Ctrl+C or systemd stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	flatfinder.Conf.PropertyTypes = os.Getenv("PROPERTY_TYPE")
-	if flatfinder.Conf.PropertyTypes == "" {
-		log.Fatal("PROPERTY_TYPE not set")
+	if *trademeAuth {
+		err = flatfinder.Conf.TrademeAuthorize(ctx, os.Stdin, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
 	// Start the stuff
-	flatfinder.Launch()
+	flatfinder.Launch(ctx)
 }
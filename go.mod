@@ -6,9 +6,11 @@ require (
 	github.com/disgoorg/disgo v0.13.19
 	github.com/disgoorg/snowflake/v2 v2.0.0
 	github.com/joho/godotenv v1.4.0
+	go.etcd.io/bbolt v1.3.7
 )
 
 require (
 	github.com/disgoorg/log v1.2.0 // indirect
 	github.com/sasha-s/go-csync v0.0.0-20210812194225-61421b77c44b // indirect
+	golang.org/x/sys v0.4.0 // indirect
 )
@@ -0,0 +1,206 @@
+package flatfinder
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// AlertKind - Why we're notifying about a listing
+type AlertKind string
+
+const (
+	AlertNew       AlertKind = "new"
+	AlertPriceDrop AlertKind = "price_drop"
+	AlertRelisted  AlertKind = "relisted"
+	AlertWithdrawn AlertKind = "withdrawn"
+	AlertDigest    AlertKind = "digest"
+)
+
+// alertRetryFor - How long an alert that failed to send is retried before it's dropped
+var alertRetryFor = 24 * time.Hour
+
+// alertTitle - Listing title with what happened to it
+func alertTitle(listing EnrichedListing) string {
+	switch listing.Alert {
+	case AlertPriceDrop:
+		return "Price dropped: " + listing.Title
+	case AlertRelisted:
+		return "Relisted: " + listing.Title
+	case AlertWithdrawn:
+		return "No longer available: " + listing.Title
+	case AlertDigest:
+		return fmt.Sprintf("Top listing (score %.0f): %s", listing.Score, listing.Title)
+	default:
+		return listing.Title
+	}
+}
+
+// priceChange - Old and new price for alerts, blank for new listings
+func priceChange(listing EnrichedListing) string {
+	if listing.Alert != AlertPriceDrop && listing.Alert != AlertRelisted {
+		return ""
+	}
+
+	previous := listing.PreviousPriceDisplay
+	if previous == "" {
+		previous = "unknown"
+	}
+
+	return fmt.Sprintf("%s -> %s", previous, listing.PriceDisplay)
+}
+
+// checkPriceDrop - Alert if the rent is lower than when we last told this search
+func (c *LocalConfig) checkPriceDrop(search *Search, stored *StoredListing) *EnrichedListing {
+	status := stored.Notifications[search.Name]
+
+	// Migrated listings have no price to compare against
+	if status.RentPerWeek == 0 || stored.Listing.RentPerWeek == 0 {
+		return nil
+	}
+
+	if stored.Listing.RentPerWeek >= status.RentPerWeek {
+		if stored.Listing.RentPerWeek > status.RentPerWeek {
+			log.Printf("[%s] Price increased: %s (%d -> %d)", search.Name, stored.Listing.Title, status.RentPerWeek, stored.Listing.RentPerWeek)
+		}
+		return nil
+	}
+
+	log.Printf("[%s] Price dropped: %s (%d -> %d)", search.Name, stored.Listing.Title, status.RentPerWeek, stored.Listing.RentPerWeek)
+
+	return &EnrichedListing{
+		Alert:                AlertPriceDrop,
+		PreviousListingID:    stored.Listing.ListingID,
+		PreviousRentPerWeek:  status.RentPerWeek,
+		PreviousPriceDisplay: status.PriceDisplay,
+	}
+}
+
+// relistedFrom - A previous listing of the same property this search was told about
+func (c *LocalConfig) relistedFrom(search *Search, listing TradeMeListing) *StoredListing {
+	previous, err := c.Store.PreviousListings(listing)
+	if err != nil {
+		log.Printf("[%s] %s", search.Name, err)
+		return nil
+	}
+
+	// Most recently sent wins
+	var relisted *StoredListing
+	for _, stored := range previous {
+		if !stored.notifiedFor(search.Name) {
+			continue
+		}
+		if relisted == nil || stored.Notifications[search.Name].SentAt.After(relisted.Notifications[search.Name].SentAt) {
+			relisted = stored
+		}
+	}
+
+	return relisted
+}
+
+// sendAlert - Notify and record what price the search was told about. Notifiers that
+// failed are tried again on later polls
+func (c *LocalConfig) sendAlert(ctx context.Context, search *Search, stored *StoredListing, enriched EnrichedListing) {
+	failed := c.notify(ctx, search, enriched)
+
+	status := NotificationStatus{
+		SentAt:       time.Now(),
+		Failed:       failed,
+		RentPerWeek:  stored.Listing.RentPerWeek,
+		PriceDisplay: stored.Listing.PriceDisplay,
+		LowPriority:  enriched.LowPriority,
+	}
+	if len(failed) > 0 {
+		status.Pending = &PendingAlert{
+			Alert:                enriched.Alert,
+			PreviousListingID:    enriched.PreviousListingID,
+			PreviousRentPerWeek:  enriched.PreviousRentPerWeek,
+			PreviousPriceDisplay: enriched.PreviousPriceDisplay,
+		}
+	}
+
+	delete(stored.Rejections, search.Name)
+	stored.Notifications[search.Name] = status
+
+	err := c.Store.PutListing(stored)
+	if err != nil {
+		log.Printf("[%s] %s", search.Name, err)
+	}
+}
+
+// retryFailedAlerts - Send alerts again to the notifiers that failed them, until they get
+// through or alertRetryFor has passed
+func (c *LocalConfig) retryFailedAlerts(ctx context.Context) {
+	listings, err := c.Store.FailedNotifications()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	for _, stored := range listings {
+		for _, search := range c.Searches {
+			status, ok := stored.Notifications[search.Name]
+			if !ok || len(status.Failed) == 0 || ctx.Err() != nil {
+				continue
+			}
+
+			// Records from before alerts were kept can't be sent again
+			if status.Pending == nil || time.Since(status.SentAt) > alertRetryFor {
+				log.Printf("[%s] Giving up on alert for %s, %s failed", search.Name, stored.Listing.Title, strings.Join(status.Failed, ", "))
+				status.Failed = nil
+				status.Pending = nil
+				stored.Notifications[search.Name] = status
+				continue
+			}
+
+			enriched := stored.enriched()
+			enriched.Alert = status.Pending.Alert
+			enriched.PreviousListingID = status.Pending.PreviousListingID
+			enriched.PreviousRentPerWeek = status.Pending.PreviousRentPerWeek
+			enriched.PreviousPriceDisplay = status.Pending.PreviousPriceDisplay
+			enriched.LowPriority = status.LowPriority
+			enriched.Score = c.scoreListing(search, enriched)
+
+			notifiers := []Notifier{}
+			for _, notifier := range c.alertNotifiers(search, status.LowPriority) {
+				if containsString(status.Failed, notifier.Name()) {
+					notifiers = append(notifiers, notifier)
+				}
+			}
+
+			status.Failed = c.notifyWith(ctx, search, notifiers, enriched)
+			if len(status.Failed) == 0 {
+				log.Printf("[%s] Sent alert for %s on retry", search.Name, stored.Listing.Title)
+				status.Pending = nil
+			}
+			stored.Notifications[search.Name] = status
+		}
+
+		err = c.Store.PutListing(stored)
+		if err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// rejectListing - Log and remember which rule turned a listing down
+func (c *LocalConfig) rejectListing(search *Search, stored *StoredListing, rule string) {
+	log.Printf("[%s] Rejected %d %s: %s", search.Name, stored.Listing.ListingID, stored.Listing.Title, rule)
+
+	if stored.Rejections == nil {
+		stored.Rejections = make(map[string]RuleRejection)
+	}
+	stored.Rejections[search.Name] = RuleRejection{
+		At:          time.Now(),
+		Rule:        rule,
+		RentPerWeek: stored.Listing.RentPerWeek,
+		Rules:       c.filterFingerprint(search),
+	}
+
+	err := c.Store.PutListing(stored)
+	if err != nil {
+		log.Printf("[%s] %s", search.Name, err)
+	}
+}
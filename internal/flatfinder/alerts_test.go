@@ -0,0 +1,279 @@
+package flatfinder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// lastAlert - The most recent thing sent, failing if nothing new was
+func lastAlert(t *testing.T, notifier *recordingNotifier, sent int) EnrichedListing {
+	t.Helper()
+
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+
+	if len(notifier.listings) != sent {
+		t.Fatalf("sent %d listings, want %d", len(notifier.listings), sent)
+	}
+	return notifier.listings[sent-1]
+}
+
+func TestPriceDropAlert(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, notifier := newTestConfig(t, fake)
+
+	err := c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastAlert(t, notifier, 3)
+
+	// Going up isn't worth telling anyone
+	fake.edit("search_page1.json", `"RentPerWeek": 650`, `"RentPerWeek": 680`, "$650 per week", "$680 per week")
+	err = c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastAlert(t, notifier, 3)
+
+	fake.edit("search_page1.json", `"RentPerWeek": 650`, `"RentPerWeek": 600`, "$650 per week", "$600 per week")
+	err = c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alert := lastAlert(t, notifier, 4)
+	if alert.Alert != AlertPriceDrop || alert.ListingID != 4211000101 {
+		t.Fatalf("sent %s for %d, want a price drop for 4211000101", alert.Alert, alert.ListingID)
+	}
+	if alert.PreviousRentPerWeek != 650 || alert.RentPerWeek != 600 || priceChange(alert) != "$650 per week -> $600 per week" {
+		t.Errorf("price change %q (%d -> %d)", priceChange(alert), alert.PreviousRentPerWeek, alert.RentPerWeek)
+	}
+
+	// Same drop isn't sent twice
+	err = c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastAlert(t, notifier, 4)
+}
+
+func TestRelistedAlert(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, notifier := newTestConfig(t, fake)
+
+	err := c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same property under a new listing ID and a lower rent
+	fake.edit("search_page1.json", "4211000101", "4211000901", `"RentPerWeek": 650`, `"RentPerWeek": 620`, "$650 per week", "$620 per week")
+	err = c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alert := lastAlert(t, notifier, 4)
+	if alert.Alert != AlertRelisted || alert.ListingID != 4211000901 || alert.PreviousListingID != 4211000101 {
+		t.Fatalf("sent %s for %d (was %d), want relisted 4211000901 (was 4211000101)", alert.Alert, alert.ListingID, alert.PreviousListingID)
+	}
+	if priceChange(alert) != "$650 per week -> $620 per week" {
+		t.Errorf("price change %q", priceChange(alert))
+	}
+}
+
+func TestCheckListingsPriceDrop(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, notifier := newTestConfig(t, fake)
+	c.WithdrawnCheckEvery = time.Hour
+
+	err := c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Too old for search to find again, only the listing check sees the drop
+	fake.edit("listing_4211000101.json", `"RentPerWeek": 650`, `"RentPerWeek": 610`, "$650 per week", "$610 per week")
+	c.checkListings(context.Background())
+
+	alert := lastAlert(t, notifier, 4)
+	if alert.Alert != AlertPriceDrop || alert.ListingID != 4211000101 || priceChange(alert) != "$650 per week -> $610 per week" {
+		t.Fatalf("sent %s for %d (%s), want a price drop for 4211000101", alert.Alert, alert.ListingID, priceChange(alert))
+	}
+
+	stored, err := c.Store.GetListing(4211000101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Listing.RentPerWeek != 610 || stored.Listing.Address != "12 Cuba Street" || stored.Listing.GeographicLocation.Latitude == 0 {
+		t.Errorf("stored listing not updated from the check: %+v", stored.Listing)
+	}
+	if len(stored.PriceHistory) != 2 {
+		t.Errorf("price history %+v", stored.PriceHistory)
+	}
+
+	// Listing 4211000102 has ended
+	ended, err := c.Store.GetListing(4211000102)
+	if err != nil || ended.ClosedAt.IsZero() {
+		t.Errorf("ended listing not closed: %v", err)
+	}
+
+	// Not due again for an hour
+	c.checkListings(context.Background())
+	if requests := fake.listingRequests("4211000101"); requests != 1 {
+		t.Errorf("listing checked %d times, want 1", requests)
+	}
+}
+
+func TestCheckListingsMigrated(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, notifier := newTestConfig(t, fake)
+	c.WithdrawnCheckEvery = time.Hour
+	c.NotifyWithdrawn = true
+
+	realChecks := listingChecksPerPoll
+	listingChecksPerPoll = 1
+	defer func() { listingChecksPerPoll = realChecks }()
+
+	// One still listed, one long gone
+	path := filepath.Join(t.TempDir(), "flatfinder.json")
+	err := os.WriteFile(path, []byte(`{"properties": {"4211000101": true, "4211000999": true}}`), 0644)
+	if err == nil {
+		err = c.Store.migrateLegacyState(path, c.Searches)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A big backlog is spread over polls
+	c.checkListings(context.Background())
+	if checked := fake.listingRequests("4211000101") + fake.listingRequests("4211000999"); checked != 1 {
+		t.Fatalf("%d listings checked in one poll, want 1", checked)
+	}
+	c.checkListings(context.Background())
+
+	// Nothing to tell anyone about listings only known by ID
+	if sent := notifier.sent(); len(sent) != 0 {
+		t.Fatalf("sent %q", sent)
+	}
+
+	gone, err := c.Store.GetListing(4211000999)
+	if err != nil || gone.ClosedAt.IsZero() {
+		t.Errorf("gone listing not closed: %v", err)
+	}
+
+	// Filled in from Trade Me, no price drop from an unknown price
+	stored, err := c.Store.GetListing(4211000101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Listing.Title != "Sunny two bedroom townhouse" || stored.Listing.Address != "12 Cuba Street" || stored.Listing.RentPerWeek != 650 {
+		t.Errorf("stored listing not updated from the check: %+v", stored.Listing)
+	}
+	if !stored.ClosedAt.IsZero() || stored.LastCheckedAt.IsZero() {
+		t.Errorf("open listing closed %s, checked %s", stored.ClosedAt, stored.LastCheckedAt)
+	}
+}
+
+// flakyNotifier - Fails while down, records what it sends once up
+type flakyNotifier struct {
+	recordingNotifier
+	down bool
+}
+
+func (n *flakyNotifier) Name() string {
+	return "flaky"
+}
+
+func (n *flakyNotifier) Notify(ctx context.Context, listing EnrichedListing) error {
+	if n.down {
+		return errors.New("service unavailable")
+	}
+
+	return n.recordingNotifier.Notify(ctx, listing)
+}
+
+func TestRetryFailedAlerts(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, working := newTestConfig(t, fake)
+	flaky := &flakyNotifier{down: true}
+	c.Searches[0].Notifiers = []Notifier{working, flaky}
+
+	err := c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(working.sent()) != 3 || len(flaky.sent()) != 0 {
+		t.Fatalf("sent %d and %d, want 3 and 0", len(working.sent()), len(flaky.sent()))
+	}
+
+	stored, err := c.Store.GetListing(4211000101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := stored.Notifications["test"]
+	if len(status.Failed) != 1 || status.Failed[0] != "flaky" || status.Pending == nil || status.Pending.Alert != AlertNew {
+		t.Fatalf("status %+v", status)
+	}
+
+	// Still down, nothing changes
+	c.retryFailedAlerts(context.Background())
+	if len(flaky.sent()) != 0 {
+		t.Fatalf("sent %d while down", len(flaky.sent()))
+	}
+
+	// Back up, only the notifier that failed gets them
+	flaky.down = false
+	c.retryFailedAlerts(context.Background())
+	if len(working.sent()) != 3 || len(flaky.sent()) != 3 {
+		t.Fatalf("sent %d and %d after retry, want 3 and 3", len(working.sent()), len(flaky.sent()))
+	}
+	if flaky.listings[0].Alert != AlertNew {
+		t.Errorf("retried alert %s, want new", flaky.listings[0].Alert)
+	}
+
+	stored, err = c.Store.GetListing(4211000101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := stored.Notifications["test"]; len(status.Failed) != 0 || status.Pending != nil {
+		t.Errorf("status after retry %+v", status)
+	}
+
+	c.retryFailedAlerts(context.Background())
+	if len(flaky.sent()) != 3 {
+		t.Errorf("sent %d after everything got through, want 3", len(flaky.sent()))
+	}
+}
+
+func TestRetryFailedAlertsGivesUp(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, _ := newTestConfig(t, fake)
+	flaky := &flakyNotifier{down: true}
+	c.Searches[0].Notifiers = []Notifier{flaky}
+
+	err := c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realRetryFor := alertRetryFor
+	alertRetryFor = 0
+	defer func() { alertRetryFor = realRetryFor }()
+
+	flaky.down = false
+	c.retryFailedAlerts(context.Background())
+	if len(flaky.sent()) != 0 {
+		t.Errorf("sent %d after giving up", len(flaky.sent()))
+	}
+
+	listings, err := c.Store.FailedNotifications()
+	if err != nil || len(listings) != 0 {
+		t.Errorf("%d listings still failed: %v", len(listings), err)
+	}
+}
@@ -0,0 +1,288 @@
+package flatfinder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+var broadbandBucket = []byte("broadband")
+
+// DefaultBroadbandCacheTTL - How long a provider's result is trusted
+var DefaultBroadbandCacheTTL = 30 * 24 * time.Hour
+
+// BroadbandProvider - Something that can say what broadband an address can get
+type BroadbandProvider interface {
+	Name() string
+
+	// Covers - Worth asking, given what earlier providers said
+	Covers(merged BroadbandInfo) bool
+	Lookup(ctx context.Context, listing TradeMeListing) (BroadbandInfo, error)
+}
+
+// BroadbandInfo - What the providers told us about an address, everything else is only
+// meaningful when Checked
+type BroadbandInfo struct {
+	Checked   bool      `json:"Checked"`
+	CheckedAt time.Time `json:"CheckedAt"`
+
+	// Whose answer fibre availability comes from, and everyone who answered
+	Provider  string   `json:"Provider,omitempty"`
+	CheckedBy []string `json:"CheckedBy,omitempty"`
+
+	// Chorus' ID for the line, addresses in the same building often share one
+	TLC int64 `json:"TLC,omitempty"`
+
+	// The Chorus address we matched, or no match was close enough to trust
+	MatchedAddress   string `json:"MatchedAddress,omitempty"`
+	AddressUncertain bool   `json:"AddressUncertain,omitempty"`
+
+	FibreBuildRequired bool   `json:"FibreBuildRequired"`
+	Hyperfibre         bool   `json:"Hyperfibre"`
+	FibreSupplier      string `json:"FibreSupplier,omitempty"`
+
+	// Chorus' fibre in another company's (LFC) area, set until that company's checker says either way
+	AlternativeFibreProvider string `json:"AlternativeFibreProvider,omitempty"`
+	FibreUnconfirmed         bool   `json:"FibreUnconfirmed,omitempty"`
+
+	// Fastest the line is capable of, and how long that takes to install
+	MaxDownMbps     float64 `json:"MaxDownMbps"`
+	MaxUpMbps       float64 `json:"MaxUpMbps"`
+	InstallLeadTime string  `json:"InstallLeadTime,omitempty"`
+
+	// What's connected right now
+	ActiveServices []BroadbandService `json:"ActiveServices,omitempty"`
+}
+
+// BroadbandService - A connection and its speeds
+type BroadbandService struct {
+	Service  string  `json:"Service"`
+	DownMbps float64 `json:"DownMbps"`
+	UpMbps   float64 `json:"UpMbps,omitempty"`
+}
+
+// FibreAvailable - Fibre is in and needs no build
+func (b BroadbandInfo) FibreAvailable() bool {
+	return b.Checked && !b.FibreBuildRequired
+}
+
+// lfcArea - The local fibre company Chorus says the area belongs to, blank for Chorus' own
+func (b BroadbandInfo) lfcArea() string {
+	for _, supplier := range []string{b.AlternativeFibreProvider, b.FibreSupplier} {
+		if supplier != "" && !strings.Contains(strings.ToLower(supplier), "chorus") {
+			return supplier
+		}
+	}
+
+	return ""
+}
+
+// fibreSummary - Like "Yes, Enable (1000/500 Mbps)", UNK if no provider could say
+func (b BroadbandInfo) fibreSummary() string {
+	if b.AddressUncertain {
+		return "UNK (address uncertain)"
+	}
+	if !b.Checked {
+		return "UNK"
+	}
+
+	summary := "Yes, " + b.Provider
+	switch {
+	case b.FibreUnconfirmed:
+		summary = fmt.Sprintf("Unconfirmed, %s area", b.lfcArea())
+	case b.FibreBuildRequired:
+		summary = "No, " + b.Provider
+	}
+
+	extras := []string{fmt.Sprintf("%.0f/%.0f Mbps", b.MaxDownMbps, b.MaxUpMbps)}
+	if b.Hyperfibre {
+		extras = append(extras, "Hyperfibre area")
+	}
+	if b.InstallLeadTime != "" {
+		extras = append(extras, "install "+b.InstallLeadTime)
+	}
+
+	return fmt.Sprintf("%s (%s)", summary, strings.Join(extras, ", "))
+}
+
+// connectionSummary - Active services like "Fibre (300 Mbps)", None if there aren't any
+func (b BroadbandInfo) connectionSummary() string {
+	if b.AddressUncertain {
+		return "UNK (address uncertain)"
+	}
+	if !b.Checked {
+		return "UNK"
+	}
+	if len(b.ActiveServices) == 0 {
+		return "None"
+	}
+
+	services := []string{}
+	for _, active := range b.ActiveServices {
+		services = append(services, fmt.Sprintf("%s (%.0f Mbps)", active.Service, active.DownMbps))
+	}
+
+	return strings.Join(services, ", ")
+}
+
+// cachedBroadbandAddress - Stored address -> TLC match, TLC 0 if none could be trusted
+type cachedBroadbandAddress struct {
+	At    time.Time `json:"at"`
+	TLC   int64     `json:"tlc"`
+	Label string    `json:"label,omitempty"`
+}
+
+// getBroadband - Ask each provider that covers the listing and merge what they say, with an
+// error if any of them couldn't be asked
+func (c *LocalConfig) getBroadband(ctx context.Context, listing TradeMeListing) (BroadbandInfo, error) {
+	merged := BroadbandInfo{}
+	var failed error
+	for _, provider := range c.broadbandProviders {
+		if !provider.Covers(merged) {
+			continue
+		}
+
+		info, err := provider.Lookup(ctx, listing)
+		if err != nil {
+			failed = fmt.Errorf("%s: %s", provider.Name(), err)
+			log.Print(failed)
+			continue
+		}
+		merged = mergeBroadband(merged, info)
+	}
+
+	return merged, failed
+}
+
+// mergeBroadband - Add a provider's answer. Fibre from anyone counts, with them named as the
+// provider, and speeds are the best anyone offers
+func mergeBroadband(merged BroadbandInfo, info BroadbandInfo) BroadbandInfo {
+	if !info.Checked {
+		merged.AddressUncertain = merged.AddressUncertain || (!merged.Checked && info.AddressUncertain)
+		return merged
+	}
+	if !merged.Checked {
+		info.CheckedBy = append(merged.CheckedBy, info.Provider)
+		return info
+	}
+
+	merged.CheckedBy = append(merged.CheckedBy, info.Provider)
+
+	// Whoever answered second was asked about the area, so it's confirmed either way
+	merged.FibreUnconfirmed = false
+	if info.FibreAvailable() && !merged.FibreAvailable() {
+		merged.FibreBuildRequired = false
+		merged.Provider = info.Provider
+		merged.InstallLeadTime = info.InstallLeadTime
+	}
+	merged.Hyperfibre = merged.Hyperfibre || info.Hyperfibre
+	merged.MaxDownMbps = math.Max(merged.MaxDownMbps, info.MaxDownMbps)
+	merged.MaxUpMbps = math.Max(merged.MaxUpMbps, info.MaxUpMbps)
+	merged.ActiveServices = append(merged.ActiveServices, info.ActiveServices...)
+
+	return merged
+}
+
+// lookupAddress - Full address to look up, built the same for every provider so their cache keys match
+func lookupAddress(listing TradeMeListing) string {
+	return fmt.Sprintf(
+		"%s, %s, %s",
+		strings.TrimSpace(listing.Address),
+		strings.TrimSpace(listing.Suburb),
+		strings.TrimSpace(listing.Region),
+	)
+}
+
+// broadbandAddressKey - Case and spacing don't matter
+func broadbandAddressKey(address string) string {
+	return "address:" + strings.ToLower(strings.Join(strings.Fields(address), " "))
+}
+
+// broadbandTLCKey - Results are kept per TLC
+func broadbandTLCKey(tlc int64) string {
+	return fmt.Sprintf("tlc:%d", tlc)
+}
+
+// cachedBroadbandAddress - The match for an address if we've looked it up within the TTL
+func (c *LocalConfig) cachedBroadbandAddress(address string) (cachedBroadbandAddress, bool) {
+	var cached cachedBroadbandAddress
+	if c.Cache == nil || c.BroadbandCacheTTL <= 0 {
+		return cached, false
+	}
+
+	found, err := c.Cache.getJSON(broadbandBucket, broadbandAddressKey(address), &cached)
+	if err != nil {
+		log.Printf("Broadband cache: %s", err)
+		return cached, false
+	}
+	if !found || time.Since(cached.At) > c.BroadbandCacheTTL {
+		return cached, false
+	}
+
+	return cached, true
+}
+
+// cacheBroadbandAddress - Remember an address match, failures are only logged
+func (c *LocalConfig) cacheBroadbandAddress(address string, match cachedBroadbandAddress) {
+	if c.Cache == nil || c.BroadbandCacheTTL <= 0 {
+		return
+	}
+
+	err := c.Cache.putJSON(broadbandBucket, broadbandAddressKey(address), match)
+	if err != nil {
+		log.Printf("Broadband cache: %s", err)
+	}
+}
+
+// cachedBroadbandInfo - A provider's results if checked within the TTL
+func (c *LocalConfig) cachedBroadbandInfo(key string) (BroadbandInfo, bool) {
+	if c.Cache == nil || c.BroadbandCacheTTL <= 0 {
+		return BroadbandInfo{}, false
+	}
+
+	var cached BroadbandInfo
+	found, err := c.Cache.getJSON(broadbandBucket, key, &cached)
+	if err != nil {
+		log.Printf("Broadband cache: %s", err)
+		return BroadbandInfo{}, false
+	}
+	if !found || time.Since(cached.CheckedAt) > c.BroadbandCacheTTL {
+		return BroadbandInfo{}, false
+	}
+
+	return cached, true
+}
+
+// cacheBroadbandInfo - Remember a provider's results, failures are only logged
+func (c *LocalConfig) cacheBroadbandInfo(key string, info BroadbandInfo) {
+	if c.Cache == nil || c.BroadbandCacheTTL <= 0 {
+		return
+	}
+
+	err := c.Cache.putJSON(broadbandBucket, key, info)
+	if err != nil {
+		log.Printf("Broadband cache: %s", err)
+	}
+}
+
+// PruneBroadband - Drop address lookups and results older than maxAge, returns how many went
+func (c *Cache) PruneBroadband(maxAge time.Duration) (int, error) {
+	return c.pruneJSON(broadbandBucket, func(v []byte) time.Time {
+		// Address lookups have "at", results "CheckedAt"
+		var cached struct {
+			At        time.Time `json:"at"`
+			CheckedAt time.Time `json:"CheckedAt"`
+		}
+		if err := json.Unmarshal(v, &cached); err != nil {
+			return time.Time{}
+		}
+		if cached.At.IsZero() {
+			return cached.CheckedAt
+		}
+		return cached.At
+	}, maxAge)
+}
@@ -0,0 +1,114 @@
+package flatfinder
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Cache - Paid or rate limited lookups, kept in their own database so wiping or restoring
+// the listing store doesn't lose or roll them back
+type Cache struct {
+	db *bolt.DB
+}
+
+// OpenCache - Open (or create) the cache database. It only holds lookups we can make
+// again, so a damaged one is started over rather than restored
+func OpenCache(path string) (*Cache, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: storeOpenTimeout})
+	if storeDamaged(err) {
+		log.Printf("%s is corrupt (%s), starting a new cache", path, err)
+		err = os.Remove(path)
+		if err == nil {
+			db, err = bolt.Open(path, 0644, &bolt.Options{Timeout: storeOpenTimeout})
+		}
+	}
+	if err != nil {
+		return nil, storeOpenError(path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{travelTimesBucket, broadbandBucket} {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Cache{db: db}, nil
+}
+
+// Close - Flush and close the database
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// getJSON - Decode the value under key into v, false if there isn't one
+func (c *Cache) getJSON(bucket []byte, key string, v interface{}) (bool, error) {
+	found := false
+
+	err := c.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+
+		found = true
+		return json.Unmarshal(data, v)
+	})
+
+	return found, err
+}
+
+// putJSON - Store v as JSON under key
+func (c *Cache) putJSON(bucket []byte, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), data)
+	})
+}
+
+// pruneJSON - Delete entries older than maxAge by the time storedAt reads from them,
+// anything it can't read counts as expired. Returns how many went
+func (c *Cache) pruneJSON(bucket []byte, storedAt func(v []byte) time.Time, maxAge time.Duration) (int, error) {
+	pruned := 0
+
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+
+		// Deleting while iterating skips keys, so collect them first
+		expired := [][]byte{}
+		err := b.ForEach(func(k, v []byte) error {
+			if time.Since(storedAt(v)) > maxAge {
+				expired = append(expired, append([]byte{}, k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		pruned = len(expired)
+		return nil
+	})
+
+	return pruned, err
+}
@@ -1,6 +1,7 @@
 package flatfinder
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,6 +9,8 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
 type ChorusAddressSearchResponse struct {
@@ -118,102 +121,191 @@ type ChorusAddressLookupResponse struct {
 	} `json:"copper"`
 }
 
-// getAvailableSpeeds - Checks if VDSL/FIBRE is available
-func getAvailableSpeeds(address string) (string, string) {
-	aid, err := chorusAddressLookup(address)
-	if err != nil {
-		log.Print(err)
-		return "UNK", "UNK"
+// ChorusAddressURL - Address lookup endpoint, overridable for tests
+var ChorusAddressURL = "https://api.chorus.co.nz/addresslookup/v1/addresses/"
+
+// ChorusBroadbandURL - Broadband capability endpoint, the TLC goes on the end
+var ChorusBroadbandURL = "https://www.chorus.co.nz/api/bbc/bcc/"
+
+// chorusProvider - Chorus' address search and broadband checker, always asked first as
+// it also says whose fibre area the address is in
+type chorusProvider struct {
+	c *LocalConfig
+}
+
+func (p *chorusProvider) Name() string {
+	return "Chorus"
+}
+
+// Covers - Chorus covers everywhere
+func (p *chorusProvider) Covers(merged BroadbandInfo) bool {
+	return true
+}
+
+// Lookup - Match the address then get its line's results. Both are cached, so a
+// building seen again costs nothing
+func (p *chorusProvider) Lookup(ctx context.Context, listing TradeMeListing) (BroadbandInfo, error) {
+	c := p.c
+	address := lookupAddress(listing)
+
+	match, ok := c.cachedBroadbandAddress(address)
+	if !ok {
+		found, err := c.chorusMatchAddress(ctx, listing, address)
+		if err != nil {
+			return BroadbandInfo{}, err
+		}
+
+		match = cachedBroadbandAddress{At: time.Now()}
+		if found != nil {
+			match.TLC, match.Label = found.TLC, found.Label
+		}
+		c.cacheBroadbandAddress(address, match)
 	}
-	// log.Printf("Using AID: %s", aid)
 
-	tlc, err := chorusGetUnqiueID(aid)
-	if err != nil {
-		log.Print(err)
-		return "UNK", "UNK"
+	if match.TLC == 0 {
+		log.Printf("broadband: address uncertain for %s", address)
+		return BroadbandInfo{AddressUncertain: true}, nil
 	}
-	// log.Printf("Using TLC: %d", tlc)
 
-	chorusURL := fmt.Sprintf("https://www.chorus.co.nz/api/bbc/bcc/%d", tlc)
-	client := http.Client{}
-	req, err := http.NewRequest("GET", chorusURL, nil)
+	info, ok := c.cachedBroadbandInfo(broadbandTLCKey(match.TLC))
+	if !ok {
+		var err error
+		info, err = chorusBroadband(ctx, match.TLC)
+		if err != nil {
+			return BroadbandInfo{}, err
+		}
+		c.cacheBroadbandInfo(broadbandTLCKey(match.TLC), info)
+	}
+
+	// Results are per line, the match is per address
+	info.Provider = p.Name()
+	info.MatchedAddress = match.Label
+	return info, nil
+}
+
+// chorusBroadband - What's available at a TLC
+func chorusBroadband(ctx context.Context, tlc int64) (BroadbandInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s%d", ChorusBroadbandURL, tlc), nil)
 	if err != nil {
-		log.Print(err)
-		return "UNK", "UNK"
+		return BroadbandInfo{}, err
 	}
 
 	// Do the request
-	resp, err := client.Do(req)
+	resp, err := chorusHTTP.Do(req)
 	if err != nil {
-		log.Print(err)
-		return "UNK", "UNK"
+		return BroadbandInfo{}, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		bodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Print(err)
-			return "UNK", "UNK"
-		}
+	if resp.StatusCode != http.StatusOK {
+		return BroadbandInfo{}, errors.New("Invalid response from API: " + resp.Status)
+	}
 
-		// Decode JSON
-		var chorusResult ChorusAddressLookupResponse
-		err = json.Unmarshal(bodyBytes, &chorusResult)
-		if err != nil {
-			log.Print(err)
-			return "UNK", "UNK"
-		}
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return BroadbandInfo{}, err
+	}
 
-		hasFibre := "No"
-		if chorusResult.Fibre.BuildRequired == "N" {
-			hasFibre = "Yes"
-		}
+	// Decode JSON
+	var chorusResult ChorusAddressLookupResponse
+	err = json.Unmarshal(bodyBytes, &chorusResult)
+	if err != nil {
+		return BroadbandInfo{}, err
+	}
 
-		maxSpeed := 0.0
-		for _, available := range chorusResult.AvailableServices {
-			if available.SpeedMbps > maxSpeed && available.Capable == "YES" {
-				maxSpeed = available.SpeedMbps
-			}
-		}
+	return chorusResult.broadbandInfo(tlc), nil
+}
 
-		current := "None"
-		for _, active := range chorusResult.ActiveServices {
-			current = fmt.Sprintf("%s (%d Mbps)", active.Service, active.SpeedMbps)
+// broadbandInfo - Pull out the parts we use
+func (r ChorusAddressLookupResponse) broadbandInfo(tlc int64) BroadbandInfo {
+	info := BroadbandInfo{
+		Checked:                  true,
+		CheckedAt:                time.Now(),
+		Provider:                 "Chorus",
+		TLC:                      tlc,
+		FibreBuildRequired:       r.Fibre.BuildRequired != "N",
+		Hyperfibre:               chorusYes(r.AreaHyperfibre),
+		FibreSupplier:            r.AreaFibreSupplier,
+		AlternativeFibreProvider: r.AlternativeFibreProvider,
+		ActiveServices:           []BroadbandService{},
+	}
+
+	// A build for Chorus doesn't mean no fibre when someone else laid it
+	info.FibreUnconfirmed = info.FibreBuildRequired && info.lfcArea() != ""
+
+	// Lead time goes with the fastest service we could get
+	for _, available := range r.AvailableServices {
+		if available.Capable != "YES" {
+			continue
+		}
+		if available.SpeedMbps > info.MaxDownMbps {
+			info.MaxDownMbps = available.SpeedMbps
+			info.InstallLeadTime = chorusLeadTime(available.InstallLeadTimeDays, available.InstallLeadTimeWeeks)
+		}
+		if available.SpeedUlMbps > info.MaxUpMbps {
+			info.MaxUpMbps = available.SpeedUlMbps
 		}
+	}
 
-		return fmt.Sprintf("%s (%.0f Mbps)", hasFibre, maxSpeed), current
-	} else {
-		log.Print("Invalid response from API: " + resp.Status)
+	for _, active := range r.ActiveServices {
+		info.ActiveServices = append(info.ActiveServices, BroadbandService{
+			Service:  active.Service,
+			DownMbps: float64(active.SpeedMbps),
+			UpMbps:   float64(active.SpeedUlMbps),
+		})
 	}
 
-	return "UNK", "UNK"
+	return info
 }
 
-// chorusAddressLookup - Try get the AID for the address
-func chorusAddressLookup(address string) (string, error) {
+// chorusYes - Chorus flags come as Y, YES or TRUE
+func chorusYes(flag string) bool {
+	switch strings.ToUpper(flag) {
+	case "Y", "YES", "TRUE":
+		return true
+	}
+
+	return false
+}
+
+// chorusLeadTime - "5 days" or "2 weeks", blank if Chorus didn't say
+func chorusLeadTime(days string, weeks string) string {
+	switch {
+	case days != "":
+		return days + " days"
+	case weeks != "":
+		return weeks + " weeks"
+	}
+
+	return ""
+}
+
+// chorusCandidate - An address the fuzzy search offered
+type chorusCandidate struct {
+	Aid   string
+	Label string
+}
+
+// chorusAddressLookup - Every address the fuzzy search offers, best first by Chorus' reckoning
+func chorusAddressLookup(ctx context.Context, auth *chorusAuth, address string) ([]chorusCandidate, error) {
 	lookupURL := fmt.Sprintf(
-		"https://api.chorus.co.nz/addresslookup/v1/addresses/?fuzzy=true&q=%s",
+		"%s?fuzzy=true&q=%s",
+		ChorusAddressURL,
 		url.QueryEscape(address),
 	)
 
 	// Build HTTP request
-	client := http.Client{}
-	req, err := http.NewRequest("GET", lookupURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", lookupURL, nil)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Magic numbers - May need to dynamically receive these
-	req.Header.Set("X-Chorus-Client-Id", "82d4b4a8050c4d5e97c5f06120ef9c04")
-	req.Header.Set("X-Chorus-Client-Secret", "8899c64746474Cf18849c6B721b5Db51")
-	req.Header.Set("X-Transaction-Id", "ca5ef871-9b3f-4af4-958d-ee9c51094e08")
 	req.Header.Set("Content-Type", "application/json")
 
-	// Do the request
-	resp, err := client.Do(req)
+	// Do the request, auth adds the credentials
+	resp, err := auth.do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
@@ -221,51 +313,50 @@ func chorusAddressLookup(address string) (string, error) {
 	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNonAuthoritativeInfo {
 		bodyBytes, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
 		// Decode JSON
 		var chorusResult ChorusAddressSearchResponse
 		err = json.Unmarshal(bodyBytes, &chorusResult)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 
-		// If we have a result, return the first one
+		// None is an answer, the address is just uncertain
+		candidates := []chorusCandidate{}
 		for _, result := range chorusResult.Results {
-			return result.Aid, nil
+			candidates = append(candidates, chorusCandidate{Aid: result.Aid, Label: result.Label})
 		}
 
-		return "", errors.New("No results found for address: " + address)
+		return candidates, nil
 	}
 
-	return "", errors.New("Invalid response from API: " + resp.Status)
+	return nil, errors.New("Invalid response from API: " + resp.Status)
 }
 
-// chorusGetUniqueID - Return ID needed to get avail services
-func chorusGetUnqiueID(aid string) (int64, error) {
+// chorusAddressDetails - Structured address, location and the TLC needed to get avail services
+func chorusAddressDetails(ctx context.Context, auth *chorusAuth, aid string) (ChorusUniqueIdResponse, error) {
+	var chorusResult ChorusUniqueIdResponse
+
 	lookupURL := fmt.Sprintf(
-		"https://api.chorus.co.nz/addresslookup/v1/addresses/aid:%s",
+		"%said:%s",
+		ChorusAddressURL,
 		aid,
 	)
 
 	// Build HTTP request
-	client := http.Client{}
-	req, err := http.NewRequest("GET", lookupURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", lookupURL, nil)
 	if err != nil {
-		return 0, err
+		return chorusResult, err
 	}
 
-	// Magic numbers - May need to dynamically receive these
-	req.Header.Set("X-Chorus-Client-Id", "82d4b4a8050c4d5e97c5f06120ef9c04")
-	req.Header.Set("X-Chorus-Client-Secret", "8899c64746474Cf18849c6B721b5Db51")
-	req.Header.Set("X-Transaction-Id", "ca5ef871-9b3f-4af4-958d-ee9c51094e08")
 	req.Header.Set("Content-Type", "application/json")
 
-	// Do the request
-	resp, err := client.Do(req)
+	// Do the request, auth adds the credentials
+	resp, err := auth.do(req)
 	if err != nil {
-		return 0, err
+		return chorusResult, err
 	}
 	defer resp.Body.Close()
 
@@ -273,18 +364,13 @@ func chorusGetUnqiueID(aid string) (int64, error) {
 	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNonAuthoritativeInfo {
 		bodyBytes, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return 0, err
+			return chorusResult, err
 		}
 
 		// Decode JSON
-		var chorusResult ChorusUniqueIdResponse
 		err = json.Unmarshal(bodyBytes, &chorusResult)
-		if err != nil {
-			return 0, err
-		}
-
-		return int64(chorusResult.References.Tlc), nil
+		return chorusResult, err
 	}
 
-	return 0, errors.New("Invalid response from API: " + resp.Status)
+	return chorusResult, errors.New("Invalid response from API: " + resp.Status)
 }
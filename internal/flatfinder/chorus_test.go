@@ -0,0 +1,304 @@
+package flatfinder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeChorus - Serves the recorded responses in testdata/chorus and counts requests by kind.
+// Address lookups need clientID, and the checker page and its script hand out script
+type fakeChorus struct {
+	URL string
+
+	mu             sync.Mutex
+	requests       map[string]int
+	clientID       string
+	script         string
+	bccFile        string
+	searchFile     string
+	transactionIDs []string
+}
+
+func newFakeChorus(t *testing.T) *fakeChorus {
+	fake := &fakeChorus{requests: map[string]int{}, clientID: DefaultChorusClientID}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		clientID, script, bccFile, searchFile := fake.clientID, fake.script, fake.bccFile, fake.searchFile
+		if strings.HasPrefix(r.URL.Path, "/addresses/") {
+			fake.transactionIDs = append(fake.transactionIDs, r.Header.Get("X-Transaction-Id"))
+		}
+		fake.mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/checker":
+			fmt.Fprint(w, `<html><script src="/static/app.js"></script><script src="https://elsewhere.example/x.js"></script></html>`)
+			return
+		case r.URL.Path == "/static/app.js":
+			fake.mu.Lock()
+			fake.requests["script"]++
+			fake.mu.Unlock()
+			fmt.Fprint(w, script)
+			return
+		case strings.HasPrefix(r.URL.Path, "/addresses/") && r.Header.Get("X-Chorus-Client-Id") != clientID:
+			fake.mu.Lock()
+			fake.requests["rejected"]++
+			fake.mu.Unlock()
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var kind, file string
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/addresses/aid:"):
+			kind, file = "aid", "address_aid.json"
+		case r.URL.Path == "/addresses/":
+			kind, file = "search", "address_search.json"
+			if searchFile != "" {
+				file = searchFile
+			}
+		case strings.HasPrefix(r.URL.Path, "/bcc/"):
+			kind, file = "bcc", "bcc_"+strings.TrimPrefix(r.URL.Path, "/bcc/")+".json"
+			if bccFile != "" {
+				file = bccFile
+			}
+		}
+
+		fake.mu.Lock()
+		fake.requests[kind]++
+		fake.mu.Unlock()
+
+		data, err := os.ReadFile(filepath.Join("testdata", "chorus", file))
+		if kind == "" || err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		// Address lookups really do come back as a 203
+		if kind != "bcc" {
+			w.WriteHeader(http.StatusNonAuthoritativeInfo)
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	fake.URL = server.URL
+
+	realAddressURL, realBroadbandURL := ChorusAddressURL, ChorusBroadbandURL
+	ChorusAddressURL, ChorusBroadbandURL = server.URL+"/addresses/", server.URL+"/bcc/"
+	t.Cleanup(func() { ChorusAddressURL, ChorusBroadbandURL = realAddressURL, realBroadbandURL })
+
+	return fake
+}
+
+// count - Requests of a kind so far
+func (f *fakeChorus) count(kind string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.requests[kind]
+}
+
+func TestGetBroadband(t *testing.T) {
+	fake := newFakeChorus(t)
+
+	cache, err := OpenCache(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	c := &LocalConfig{Cache: cache, BroadbandCacheTTL: time.Hour, ChorusMatchDistance: 200}
+	c.initPipeline()
+
+	listing := TradeMeListing{Address: "12 Cuba Street", Suburb: "Te Aro", Region: "Wellington"}
+	listing.GeographicLocation.Latitude = -41.2925
+	listing.GeographicLocation.Longitude = 174.7758
+
+	info, _ := c.getBroadband(context.Background(), listing)
+	want := BroadbandInfo{
+		Checked:         true,
+		CheckedAt:       info.CheckedAt,
+		Provider:        "Chorus",
+		CheckedBy:       []string{"Chorus"},
+		TLC:             1234567,
+		MatchedAddress:  "12 Cuba Street, Te Aro, Wellington",
+		Hyperfibre:      true,
+		FibreSupplier:   "Chorus",
+		MaxDownMbps:     900,
+		MaxUpMbps:       500,
+		InstallLeadTime: "5 days",
+		ActiveServices:  []BroadbandService{{Service: "Fibre", DownMbps: 300, UpMbps: 100}},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Fatalf("got %+v, want %+v", info, want)
+	}
+	if summary := info.fibreSummary(); summary != "Yes, Chorus (900/500 Mbps, Hyperfibre area, install 5 days)" {
+		t.Errorf("fibre summary %q", summary)
+	}
+	if summary := info.connectionSummary(); summary != "Fibre (300 Mbps)" {
+		t.Errorf("connection summary %q", summary)
+	}
+
+	// Same address, written differently, is served from the cache
+	spaced := listing
+	spaced.Address, spaced.Region = "12  cuba street", "WELLINGTON"
+	cached, _ := c.getBroadband(context.Background(), spaced)
+	if !cached.CheckedAt.Equal(info.CheckedAt) || cached.TLC != info.TLC {
+		t.Errorf("cached %+v, want %+v", cached, info)
+	}
+	for _, kind := range []string{"search", "aid", "bcc"} {
+		if fake.count(kind) != 1 {
+			t.Errorf("%d %s requests, want 1", fake.count(kind), kind)
+		}
+	}
+
+	// A flat in the same building matches Chorus' building level address, and only
+	// needs the lookups, not the results
+	flat := listing
+	flat.Address = "Flat 2, 12 Cuba Street"
+	if info, _ := c.getBroadband(context.Background(), flat); info.TLC != 1234567 {
+		t.Errorf("flat got %+v", info)
+	}
+	if fake.count("search") != 2 || fake.count("bcc") != 1 {
+		t.Errorf("%d searches and %d results, want 2 and 1", fake.count("search"), fake.count("bcc"))
+	}
+
+	// The fuzzy search's answer is the wrong street number, or too far away
+	wrongNumber := listing
+	wrongNumber.Address = "99 Cuba Street"
+	farAway := listing
+	farAway.Address = "12 Cuba St"
+	farAway.GeographicLocation.Latitude = -41.3
+	for _, uncertain := range []TradeMeListing{wrongNumber, farAway} {
+		info, _ := c.getBroadband(context.Background(), uncertain)
+		if !info.AddressUncertain || info.Checked || info.fibreSummary() != "UNK (address uncertain)" {
+			t.Errorf("%s got %+v", uncertain.Address, info)
+		}
+	}
+}
+
+func TestChorusCredentialRefresh(t *testing.T) {
+	fake := newFakeChorus(t)
+	listing := TradeMeListing{Address: "12 Cuba Street", Suburb: "Te Aro", Region: "Wellington"}
+
+	// Chorus has moved on from our credentials, the checker's script has the new ones
+	fake.mu.Lock()
+	fake.clientID = "0123456789abcdef0123456789abcdef"
+	fake.script = `fetch(u, {headers: {"X-Chorus-Client-Id": "0123456789abcdef0123456789abcdef", "X-Chorus-Client-Secret": "fedcba9876543210fedcba9876543210"}})`
+	fake.mu.Unlock()
+
+	c := &LocalConfig{ChorusCredentialsURL: fake.URL + "/checker"}
+	c.initPipeline()
+
+	info, _ := c.getBroadband(context.Background(), listing)
+	if !info.Checked {
+		t.Fatalf("got %+v after refresh", info)
+	}
+	if clientID, secret := c.chorusAuth.credentials(); clientID != "0123456789abcdef0123456789abcdef" || secret != "fedcba9876543210fedcba9876543210" {
+		t.Errorf("credentials %s %s", clientID, secret)
+	}
+	if fake.count("rejected") != 1 || fake.count("script") != 1 {
+		t.Errorf("%d rejected and %d script fetches, want 1 and 1", fake.count("rejected"), fake.count("script"))
+	}
+
+	// Every request gets its own transaction ID
+	fake.mu.Lock()
+	transactionIDs := append([]string{}, fake.transactionIDs...)
+	fake.mu.Unlock()
+	seen := map[string]bool{}
+	for _, id := range transactionIDs {
+		if len(id) != 36 || seen[id] {
+			t.Errorf("transaction ID %q reused or malformed", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestChorusDegraded(t *testing.T) {
+	fake := newFakeChorus(t)
+	listing := TradeMeListing{Address: "12 Cuba Street", Suburb: "Te Aro", Region: "Wellington"}
+
+	// Credentials rejected and the checker has nothing better
+	fake.mu.Lock()
+	fake.clientID = "0123456789abcdef0123456789abcdef"
+	fake.mu.Unlock()
+
+	c := &LocalConfig{ChorusCredentialsURL: fake.URL + "/checker"}
+	c.initPipeline()
+
+	if info, _ := c.getBroadband(context.Background(), listing); info.Checked || info.AddressUncertain {
+		t.Fatalf("got %+v with no working credentials", info)
+	}
+
+	// Paused, so the next listing doesn't even try
+	c.getBroadband(context.Background(), listing)
+	if fake.count("rejected") != 1 || fake.count("script") != 1 {
+		t.Errorf("%d rejected and %d script fetches, want 1 and 1", fake.count("rejected"), fake.count("script"))
+	}
+}
+
+func TestParseListingAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    listingAddress
+	}{
+		{"15 Smith Street", listingAddress{Number: "15", Street: "smith"}},
+		{"2/15 Smith St", listingAddress{Unit: "2", Number: "15", Street: "smith"}},
+		{"Flat 2, 15A Smith Street", listingAddress{Unit: "2", Number: "15a", Street: "smith"}},
+		{"Unit B 15 Smith Street, Te Aro", listingAddress{Unit: "b", Number: "15", Street: "smith"}},
+		{"Smith Street", listingAddress{Street: "smith"}},
+	}
+	for _, test := range tests {
+		test.want.Suburb = "te aro"
+		got := parseListingAddress(TradeMeListing{Address: test.address, Suburb: "Te Aro"})
+		if got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.address, got, test.want)
+		}
+	}
+}
+
+func TestListingAddressScore(t *testing.T) {
+	candidate := func(unit interface{}, number int, street string) ChorusUniqueIdResponse {
+		var details ChorusUniqueIdResponse
+		details.StructuredAddress.Unit = unit
+		details.StructuredAddress.StreetNumber = number
+		details.StructuredAddress.StreetName = street
+		details.StructuredAddress.Suburb = "Te Aro"
+		details.Location.Wgs84Lat, details.Location.Wgs84Lon = -41.2924, 174.7757
+		return details
+	}
+
+	flat := listingAddress{Unit: "2", Number: "15", Street: "smith", Suburb: "te aro", Latitude: -41.2924, Longitude: 174.7757}
+
+	exact, ok := flat.score(candidate("2", 15, "Smith"), 200)
+	if !ok {
+		t.Fatal("exact match rejected")
+	}
+	building, ok := flat.score(candidate(nil, 15, "Smith"), 200)
+	if !ok || building >= exact {
+		t.Errorf("building %v (%v), want below exact %v", building, ok, exact)
+	}
+
+	for name, details := range map[string]ChorusUniqueIdResponse{
+		"other unit":   candidate(3, 15, "Smith"),
+		"other number": candidate("2", 115, "Smith"),
+		"other street": candidate("2", 15, "Jones"),
+	} {
+		if _, ok := flat.score(details, 200); ok {
+			t.Errorf("%s accepted", name)
+		}
+	}
+
+	// No number and no location can't be checked at all
+	if _, ok := (listingAddress{Street: "smith"}).score(candidate(nil, 15, "Smith"), 200); ok {
+		t.Error("unverifiable address accepted")
+	}
+}
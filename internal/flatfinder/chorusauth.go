@@ -0,0 +1,244 @@
+package flatfinder
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Public credentials the Chorus broadband checker uses, until config or a refresh says otherwise
+var (
+	DefaultChorusClientID     = "82d4b4a8050c4d5e97c5f06120ef9c04"
+	DefaultChorusClientSecret = "8899c64746474Cf18849c6B721b5Db51"
+)
+
+// ChorusCredentialsURL - Broadband checker page the current credentials are scraped from
+var ChorusCredentialsURL = "https://www.chorus.co.nz/broadband-checker"
+
+// chorusDegradedFor - How long address lookups stop after a refresh fails, before trying again
+var chorusDegradedFor = time.Hour
+
+// chorusMaxScripts - Most scripts on the checker page searched for credentials
+var chorusMaxScripts = 10
+
+// Credentials as they appear in the page or its scripts, as JSON, headers or JS
+var (
+	chorusClientIDPattern     = regexp.MustCompile(`(?i)client[-_]?id["']?\s*[:=,]\s*["']([0-9a-z]{32})["']`)
+	chorusClientSecretPattern = regexp.MustCompile(`(?i)client[-_]?secret["']?\s*[:=,]\s*["']([0-9a-z]{32})["']`)
+	chorusScriptPattern       = regexp.MustCompile(`(?i)<script[^>]+src=["']([^"']+)["']`)
+)
+
+// chorusAuth - Client credentials for the address API, shared by every lookup and
+// replaced when Chorus stops accepting them
+type chorusAuth struct {
+	credentialsURL string
+
+	mu            sync.Mutex
+	clientID      string
+	clientSecret  string
+	degradedUntil time.Time
+}
+
+// newChorusAuth - Start from configured credentials, or the defaults
+func newChorusAuth(clientID string, clientSecret string, credentialsURL string) *chorusAuth {
+	if clientID == "" {
+		clientID, clientSecret = DefaultChorusClientID, DefaultChorusClientSecret
+	}
+	if credentialsURL == "" {
+		credentialsURL = ChorusCredentialsURL
+	}
+
+	return &chorusAuth{clientID: clientID, clientSecret: clientSecret, credentialsURL: credentialsURL}
+}
+
+// credentials - The current client ID and secret
+func (a *chorusAuth) credentials() (string, string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.clientID, a.clientSecret
+}
+
+// do - Send a request with the credentials and a new transaction ID. On a 401 or 403 the
+// credentials are refreshed and the request tried once more
+func (a *chorusAuth) do(req *http.Request) (*http.Response, error) {
+	a.mu.Lock()
+	degradedUntil := a.degradedUntil
+	a.mu.Unlock()
+	if time.Now().Before(degradedUntil) {
+		return nil, fmt.Errorf("Chorus credentials rejected, address lookups paused until %s", degradedUntil.Format(time.Kitchen))
+	}
+
+	clientID, clientSecret := a.credentials()
+	resp, err := a.send(req, clientID, clientSecret)
+	if err != nil || !chorusRejected(resp) {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	err = a.refresh(req.Context(), clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID, clientSecret = a.credentials()
+	resp, err = a.send(req, clientID, clientSecret)
+	if err == nil && chorusRejected(resp) {
+		a.degrade(errors.New("refreshed credentials rejected too"))
+	}
+
+	return resp, err
+}
+
+// send - One attempt with the given credentials
+func (a *chorusAuth) send(req *http.Request, clientID string, clientSecret string) (*http.Response, error) {
+	transactionID, err := newTransactionID()
+	if err != nil {
+		return nil, err
+	}
+
+	attempt := req.Clone(req.Context())
+	attempt.Header.Set("X-Chorus-Client-Id", clientID)
+	attempt.Header.Set("X-Chorus-Client-Secret", clientSecret)
+	attempt.Header.Set("X-Transaction-Id", transactionID)
+
+	return chorusHTTP.Do(attempt)
+}
+
+// chorusRejected - Chorus says no to the credentials rather than the request
+func chorusRejected(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden
+}
+
+// refresh - Scrape new credentials, unless another lookup already replaced the rejected ones
+func (a *chorusAuth) refresh(ctx context.Context, rejectedID string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.clientID != rejectedID {
+		return nil
+	}
+	if time.Now().Before(a.degradedUntil) {
+		return fmt.Errorf("Chorus credentials rejected, address lookups paused until %s", a.degradedUntil.Format(time.Kitchen))
+	}
+
+	log.Print("Chorus rejected our client credentials, fetching current ones")
+	clientID, clientSecret, err := scrapeChorusCredentials(ctx, a.credentialsURL)
+	if err == nil && clientID == rejectedID {
+		err = errors.New("checker page still has the rejected credentials")
+	}
+	if err != nil {
+		a.degradeLocked(err)
+		return fmt.Errorf("Failed to refresh Chorus credentials: %s", err)
+	}
+
+	a.clientID, a.clientSecret = clientID, clientSecret
+	log.Print("Using refreshed Chorus client credentials")
+
+	return nil
+}
+
+// degrade - Pause address lookups for a while so we aren't hammering Chorus
+func (a *chorusAuth) degrade(reason error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.degradeLocked(reason)
+}
+
+// degradeLocked - degrade with mu held
+func (a *chorusAuth) degradeLocked(reason error) {
+	if time.Now().Before(a.degradedUntil) {
+		return
+	}
+
+	a.degradedUntil = time.Now().Add(chorusDegradedFor)
+	log.Printf(
+		"Chorus credentials not working (%s). Broadband will show as unknown until %s, set chorus.client_id and chorus.client_secret (CHORUS_CLIENT_ID, CHORUS_CLIENT_SECRET) to fix",
+		reason,
+		a.degradedUntil.Format(time.Kitchen),
+	)
+}
+
+// scrapeChorusCredentials - Find a client ID and secret in the checker page or its scripts
+func scrapeChorusCredentials(ctx context.Context, pageURL string) (string, string, error) {
+	page, err := fetchChorusPage(ctx, pageURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	sources := []string{page}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return "", "", err
+	}
+	for _, match := range chorusScriptPattern.FindAllStringSubmatch(page, chorusMaxScripts) {
+		script, err := base.Parse(match[1])
+		if err != nil || script.Host != base.Host {
+			continue
+		}
+		sources = append(sources, script.String())
+	}
+
+	for i, source := range sources {
+		body := source
+		if i > 0 {
+			body, err = fetchChorusPage(ctx, source)
+			if err != nil {
+				log.Printf("Chorus credentials: %s", err)
+				continue
+			}
+		}
+
+		id := chorusClientIDPattern.FindStringSubmatch(body)
+		secret := chorusClientSecretPattern.FindStringSubmatch(body)
+		if id != nil && secret != nil {
+			return id[1], secret[1], nil
+		}
+	}
+
+	return "", "", errors.New("No client credentials found on " + pageURL)
+}
+
+// fetchChorusPage - GET a page, capped at 5MB
+func fetchChorusPage(ctx context.Context, pageURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := chorusHTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("Invalid response from " + pageURL + ": " + resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 5<<20))
+	return string(body), err
+}
+
+// newTransactionID - Random UUID v4, Chorus wants a new one per request
+func newTransactionID() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16]), nil
+}
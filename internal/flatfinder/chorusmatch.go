@@ -0,0 +1,197 @@
+package flatfinder
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// DefaultChorusMatchDistance - Metres a Chorus address can be from the listing's pin
+var DefaultChorusMatchDistance = 200.0
+
+// chorusMaxCandidates - Most search results we look up details for
+var chorusMaxCandidates = 3
+
+// streetAddressPattern - "Flat 2, 15A Smith Street", "2/15 Smith St", "15 Smith Street"
+var streetAddressPattern = regexp.MustCompile(`(?i)^(?:(?:flat|unit|apartment|apt)\s*([0-9a-z]+)\s*,?\s*)?(?:([0-9a-z]+)\s*/\s*)?(\d+\s*[a-z]?)\s+(.+)$`)
+
+// listingAddress - The parts of a listing's address we match Chorus results against
+type listingAddress struct {
+	Unit   string
+	Number string
+	Street string
+	Suburb string
+
+	// Zero if Trade Me didn't give a location
+	Latitude  float64
+	Longitude float64
+}
+
+// parseListingAddress - Split the street address up, anything we can't parse is left blank
+func parseListingAddress(listing TradeMeListing) listingAddress {
+	address := listingAddress{
+		Suburb:    normaliseAddressPart(listing.Suburb),
+		Latitude:  listing.GeographicLocation.Latitude,
+		Longitude: listing.GeographicLocation.Longitude,
+	}
+
+	street := strings.TrimSpace(listing.Address)
+	match := streetAddressPattern.FindStringSubmatch(street)
+	if match == nil {
+		address.Street = firstWord(street)
+		return address
+	}
+
+	address.Unit = strings.ToLower(match[1] + match[2])
+	address.Number = strings.ToLower(strings.Join(strings.Fields(match[3]), ""))
+
+	// Anything after a comma is suburb or city
+	address.Street = firstWord(strings.Split(match[4], ",")[0])
+
+	return address
+}
+
+// normaliseAddressPart - Lower case with single spaces
+func normaliseAddressPart(part string) string {
+	return strings.ToLower(strings.Join(strings.Fields(part), " "))
+}
+
+// firstWord - Street names are compared on their first word so "St" and "Street" agree
+func firstWord(s string) string {
+	fields := strings.Fields(normaliseAddressPart(s))
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return fields[0]
+}
+
+// chorusString - Chorus sends some address parts as strings, some as numbers, some as null
+func chorusString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+
+	return strings.ToLower(strings.TrimSpace(fmt.Sprint(value)))
+}
+
+// labelMentions - The search result's label has the street number as a word, so "15"
+// is in "2/15 Smith St" but not "115 Smith St"
+func labelMentions(label string, number string) bool {
+	words := strings.FieldsFunc(strings.ToLower(label), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	for _, word := range words {
+		if word == number {
+			return true
+		}
+	}
+
+	return false
+}
+
+// distanceMetres - Great circle distance between two WGS84 points
+func distanceMetres(lat1 float64, long1 float64, lat2 float64, long2 float64) float64 {
+	const earthRadius = 6371000.0
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLat := toRadians(lat2 - lat1)
+	dLong := toRadians(long2 - long1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLong/2)*math.Sin(dLong/2)
+
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// score - How well a Chorus address fits the listing, false if it can't be the same place.
+// Street number, street, unit and distance rule candidates out, unit and suburb add to the score
+func (l listingAddress) score(candidate ChorusUniqueIdResponse, maxDistance float64) (float64, bool) {
+	structured := candidate.StructuredAddress
+	located := l.Latitude != 0 || l.Longitude != 0
+	chorusLocated := candidate.Location.Wgs84Lat != 0 || candidate.Location.Wgs84Lon != 0
+
+	// Need a number or a location to be sure of anything
+	if l.Number == "" && !(located && chorusLocated) {
+		return 0, false
+	}
+
+	score := 0.0
+
+	if l.Number != "" && fmt.Sprintf("%d%s", structured.StreetNumber, chorusString(structured.Suffix)) != l.Number {
+		return 0, false
+	}
+	if street := firstWord(structured.StreetName); l.Street != "" && street != "" && street != l.Street {
+		return 0, false
+	}
+
+	// A building level result is fine for broadband, an exact unit is better
+	unit := chorusString(structured.Unit)
+	switch {
+	case l.Unit != "" && unit != "" && unit != l.Unit:
+		return 0, false
+	case l.Unit == unit:
+		score += 2
+	}
+
+	if l.Suburb != "" && (l.Suburb == normaliseAddressPart(structured.Suburb) || l.Suburb == normaliseAddressPart(structured.Town)) {
+		score++
+	}
+
+	if located && chorusLocated {
+		distance := distanceMetres(l.Latitude, l.Longitude, candidate.Location.Wgs84Lat, candidate.Location.Wgs84Lon)
+		if distance > maxDistance {
+			return 0, false
+		}
+		score += 1 - distance/maxDistance
+	}
+
+	return score, true
+}
+
+// chorusMatch - The search result we trust for a listing
+type chorusMatch struct {
+	TLC   int64
+	Label string
+}
+
+// chorusMatchAddress - Look up the likely search results and pick the best fit. Nil with no
+// error means none were close enough to trust
+func (c *LocalConfig) chorusMatchAddress(ctx context.Context, listing TradeMeListing, query string) (*chorusMatch, error) {
+	candidates, err := chorusAddressLookup(ctx, c.chorusAuth, query)
+	if err != nil {
+		return nil, err
+	}
+
+	address := parseListingAddress(listing)
+	maxDistance := c.ChorusMatchDistance
+	if maxDistance <= 0 {
+		maxDistance = DefaultChorusMatchDistance
+	}
+
+	var best *chorusMatch
+	bestScore := 0.0
+	checked := 0
+	for _, candidate := range candidates {
+		if checked == chorusMaxCandidates {
+			break
+		}
+		if address.Number != "" && !labelMentions(candidate.Label, address.Number) {
+			continue
+		}
+		checked++
+
+		details, err := chorusAddressDetails(ctx, c.chorusAuth, candidate.Aid)
+		if err != nil {
+			return nil, err
+		}
+
+		score, ok := address.score(details, maxDistance)
+		if ok && (best == nil || score > bestScore) {
+			best = &chorusMatch{TLC: int64(details.References.Tlc), Label: candidate.Label}
+			bestScore = score
+		}
+	}
+
+	return best, nil
+}
@@ -0,0 +1,605 @@
+package flatfinder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultConfigFile - Looked for in the working directory then the config dir
+var DefaultConfigFile = "config.json"
+
+// FileConfig - Layout of the JSON config file
+type FileConfig struct {
+	TradeMe struct {
+		Key      string `json:"key"`
+		Secret   string `json:"secret"`
+		MaxPages *int   `json:"max_pages"`
+
+		// "production" (default) or "sandbox"
+		Environment string `json:"environment"`
+		// Replace the environment's URLs, e.g. to point at a fake server
+		BaseURL  string `json:"base_url"`
+		OAuthURL string `json:"oauth_url"`
+
+		// Member token, normally saved by --trademe-auth instead
+		Token       string `json:"token"`
+		TokenSecret string `json:"token_secret"`
+
+		// Like "2 hours ago" or "2h", how far back the first search looks
+		Since string `json:"since"`
+		// Go duration, re-search this far before the last poll to catch late listings
+		PollOverlap string `json:"poll_overlap"`
+		// Go duration like "6h" (the default), "0s" disables
+		// Go duration like "6h", blank disables
+		WithdrawnCheckEvery string `json:"withdrawn_check_every"`
+		NotifyWithdrawn     bool   `json:"notify_withdrawn"`
+
+		// Look up the full listing for new listings, cached so it's fetched once
+		FetchDetails bool `json:"fetch_details"`
+	} `json:"trademe"`
+
+	Google struct {
+		APIKey string `json:"api_key"`
+
+		// Go duration results are reused for, "0s" disables the cache
+		CacheTTL string `json:"cache_ttl"`
+		// Decimal places of lat/long in the cache key, nearby listings share results
+		CachePrecision *int `json:"cache_precision"`
+	} `json:"google"`
+
+	Chorus struct {
+		// Go duration address lookups and results are reused for, "0s" disables the cache
+		CacheTTL string `json:"cache_ttl"`
+
+		// Metres a matched address can be from the listing's location
+		MaxMatchDistance float64 `json:"max_match_distance"`
+
+		// Address API credentials, blank uses the public ones the broadband checker does
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		// Page new credentials are scraped from when Chorus rejects ours
+		CredentialsURL string `json:"credentials_url"`
+	} `json:"chorus"`
+
+	// Local fibre company address checkers, asked when Chorus says it's their area
+	LFCCheckers []*LFCChecker `json:"lfc_checkers"`
+
+	Destinations []Destination `json:"destinations"`
+
+	// Concurrency for looking up new listings, 0 uses the default
+	Enrich struct {
+		Workers            int `json:"workers"`
+		ChorusConcurrency  int `json:"chorus_concurrency"`
+		GoogleConcurrency  int `json:"google_concurrency"`
+		TradeMeConcurrency int `json:"trademe_concurrency"`
+	} `json:"enrich"`
+
+	// Global notifiers, searches without their own settings use these
+	NotifierSettings
+
+	// Listings must pass every rule, for all searches
+	Rules []*Rule `json:"rules"`
+
+	// Weights for scoring listings, and a periodic digest of the best ones
+	Scoring struct {
+		Weights *ScoreWeights `json:"weights"`
+
+		// Go durations, travel this long scores nothing
+		TravelMax string `json:"travel_max"`
+
+		// Blank disables the digest, max age skips listings first seen longer ago
+		DigestEvery  string `json:"digest_every"`
+		DigestSize   int    `json:"digest_size"`
+		DigestMaxAge string `json:"digest_max_age"`
+	} `json:"scoring"`
+
+	// Broadband and travel limits for all searches, checked after enrichment
+	Requirements *Requirements `json:"requirements"`
+
+	Searches []*Search `json:"searches"`
+}
+
+// Destination - Somewhere we want travel times to
+type Destination struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+
+	// walking (default), driving, transit or bicycling
+	Mode string `json:"mode,omitempty"`
+	// Transit only, like "08:30" on the next weekday
+	ArrivalTime string `json:"arrival_time,omitempty"`
+}
+
+// ConfigErrors - Every problem found while validating config
+type ConfigErrors []string
+
+func (e ConfigErrors) Error() string {
+	return "Invalid config:\n  " + strings.Join(e, "\n  ")
+}
+
+// LoadConfig - Build config from a file, or from env vars if there isn't one
+func LoadConfig(path string, stateDir string, cacheDir string) (LocalConfig, error) {
+	if path == "" {
+		path = findFile(DefaultConfigFile, filepath.Join(getConfigDir(), DefaultConfigFile))
+	}
+
+	var fileConfig FileConfig
+	var err error
+	if path != "" {
+		fileConfig, err = readFileConfig(path)
+		log.Printf("Loading config from %s", path)
+	} else {
+		fileConfig, err = envFileConfig()
+		log.Print("No config file, loading config from env")
+	}
+	if err != nil {
+		return LocalConfig{}, err
+	}
+
+	// Secrets can always come from env so they stay out of the file
+	fileConfig.applyEnvOverrides()
+
+	problems := fileConfig.validate()
+	if len(problems) > 0 {
+		return LocalConfig{}, problems
+	}
+
+	if fileConfig.Google.APIKey == "" {
+		log.Print("GOOGLE_API_KEY not set. Not using map logic")
+	}
+
+	c := fileConfig.localConfig()
+	c.StateDir, err = resolveStateDir(stateDir)
+	if err != nil {
+		return c, fmt.Errorf("Failed to create state dir: %s", err)
+	}
+	log.Printf("Using state dir %s", c.StateDir)
+
+	c.CacheDir, err = resolveCacheDir(cacheDir)
+	if err != nil {
+		return c, fmt.Errorf("Failed to create cache dir: %s", err)
+	}
+	log.Printf("Using cache dir %s", c.CacheDir)
+
+	err = c.loadStoredToken()
+	if err != nil {
+		return c, err
+	}
+	if c.TradeMeToken != "" {
+		log.Print("Using Trade Me member token")
+	}
+
+	return c, nil
+}
+
+// readFileConfig - Parse a JSON config file
+func readFileConfig(path string) (FileConfig, error) {
+	var fileConfig FileConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fileConfig, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&fileConfig)
+	if err != nil {
+		return fileConfig, fmt.Errorf("Invalid %s: %s", path, err)
+	}
+
+	return fileConfig, nil
+}
+
+// envFileConfig - The original env var only configuration
+func envFileConfig() (FileConfig, error) {
+	var fileConfig FileConfig
+
+	// Searches file replaces the single env search
+	searchesFile := os.Getenv("SEARCHES_FILE")
+	if searchesFile == "" {
+		searchesFile = findFile("searches.json", filepath.Join(getConfigDir(), "searches.json"))
+	}
+	if searchesFile != "" && fileExists(searchesFile) {
+		searchesConfig, err := readFileConfig(searchesFile)
+		if err != nil {
+			return fileConfig, err
+		}
+		fileConfig.Searches = searchesConfig.Searches
+	} else {
+		fileConfig.Searches = []*Search{{
+			Name:          "default",
+			Suburbs:       os.Getenv("SUBURBS"),
+			BedroomsMin:   os.Getenv("BEDROOMS_MIN"),
+			BedroomsMax:   os.Getenv("BEDROOMS_MAX"),
+			PriceMax:      os.Getenv("PRICE_MAX"),
+			PropertyTypes: os.Getenv("PROPERTY_TYPE"),
+		}}
+	}
+
+	// Default to discord if a webhook is set
+	fileConfig.Types = os.Getenv("NOTIFIERS")
+	if fileConfig.Types == "" && os.Getenv("DISCORD_WEBHOOK") != "" {
+		fileConfig.Types = "discord"
+	}
+	fileConfig.DiscordTag = os.Getenv("DISCORD_TAG")
+
+	for _, key := range []string{"GOOGLE_LOCATION_1", "GOOGLE_LOCATION_2"} {
+		if address := os.Getenv(key); address != "" {
+			fileConfig.Destinations = append(fileConfig.Destinations, Destination{Name: address, Address: address, Mode: os.Getenv("GOOGLE_MODE")})
+		}
+	}
+
+	if maxPages := os.Getenv("TRADEME_MAX_PAGES"); maxPages != "" {
+		pages, err := strconv.Atoi(maxPages)
+		if err != nil {
+			return fileConfig, fmt.Errorf("TRADEME_MAX_PAGES must be a number")
+		}
+		fileConfig.TradeMe.MaxPages = &pages
+	}
+
+	enrichLimits := map[string]*int{
+		"ENRICH_WORKERS":      &fileConfig.Enrich.Workers,
+		"CHORUS_CONCURRENCY":  &fileConfig.Enrich.ChorusConcurrency,
+		"GOOGLE_CONCURRENCY":  &fileConfig.Enrich.GoogleConcurrency,
+		"TRADEME_CONCURRENCY": &fileConfig.Enrich.TradeMeConcurrency,
+	}
+	for key, value := range enrichLimits {
+		if env := os.Getenv(key); env != "" {
+			limit, err := strconv.Atoi(env)
+			if err != nil {
+				return fileConfig, fmt.Errorf("%s must be a number", key)
+			}
+			*value = limit
+		}
+	}
+
+	fileConfig.Google.CacheTTL = os.Getenv("GOOGLE_CACHE_TTL")
+	fileConfig.Chorus.CacheTTL = os.Getenv("CHORUS_CACHE_TTL")
+	fileConfig.Chorus.CredentialsURL = os.Getenv("CHORUS_CREDENTIALS_URL")
+	fileConfig.TradeMe.Environment = os.Getenv("TRADEME_ENV")
+	fileConfig.TradeMe.BaseURL = os.Getenv("TRADEME_BASE_URL")
+	fileConfig.TradeMe.OAuthURL = os.Getenv("TRADEME_OAUTH_URL")
+	fileConfig.TradeMe.Since = os.Getenv("SINCE")
+	fileConfig.TradeMe.PollOverlap = os.Getenv("POLL_OVERLAP")
+	fileConfig.TradeMe.WithdrawnCheckEvery = os.Getenv("WITHDRAWN_CHECK_EVERY")
+	fileConfig.TradeMe.NotifyWithdrawn = os.Getenv("NOTIFY_WITHDRAWN") == "true"
+	fileConfig.TradeMe.FetchDetails = os.Getenv("FETCH_DETAILS") == "true"
+	fileConfig.Scoring.DigestEvery = os.Getenv("DIGEST_EVERY")
+	if digestSize := os.Getenv("DIGEST_SIZE"); digestSize != "" {
+		size, err := strconv.Atoi(digestSize)
+		if err != nil {
+			return fileConfig, fmt.Errorf("DIGEST_SIZE must be a number")
+		}
+		fileConfig.Scoring.DigestSize = size
+	}
+
+	return fileConfig, nil
+}
+
+// parseSince - Accepts a Go duration ("2h") or "2 hours ago"
+func parseSince(since string) (time.Duration, error) {
+	duration, err := time.ParseDuration(since)
+	if err == nil && duration > 0 {
+		return duration, nil
+	}
+
+	units := map[string]time.Duration{
+		"minute": time.Minute,
+		"hour":   time.Hour,
+		"day":    24 * time.Hour,
+		"week":   7 * 24 * time.Hour,
+	}
+
+	parts := strings.Fields(strings.TrimSuffix(strings.TrimSpace(since), " ago"))
+	if len(parts) == 2 {
+		count, err := strconv.Atoi(parts[0])
+		unit, ok := units[strings.TrimSuffix(strings.ToLower(parts[1]), "s")]
+		if err == nil && ok && count > 0 {
+			return time.Duration(count) * unit, nil
+		}
+	}
+
+	return 0, fmt.Errorf("must be like \"2 hours ago\" or 2h, got %q", since)
+}
+
+// applyEnvOverrides - Env vars win over the file for secrets
+func (f *FileConfig) applyEnvOverrides() {
+	overrides := map[string]*string{
+		"TRADEME_API_KEY":      &f.TradeMe.Key,
+		"TRADEME_API_SECRET":   &f.TradeMe.Secret,
+		"TRADEME_TOKEN":        &f.TradeMe.Token,
+		"TRADEME_TOKEN_SECRET": &f.TradeMe.TokenSecret,
+		"GOOGLE_API_KEY":       &f.Google.APIKey,
+		"CHORUS_CLIENT_ID":     &f.Chorus.ClientID,
+		"CHORUS_CLIENT_SECRET": &f.Chorus.ClientSecret,
+		"DISCORD_WEBHOOK":      &f.DiscordWebhook,
+		"WEBHOOK_URL":          &f.WebhookURL,
+	}
+
+	for key, value := range overrides {
+		if env := os.Getenv(key); env != "" {
+			*value = env
+		}
+	}
+}
+
+// validate - Return every problem rather than stopping at the first
+func (f *FileConfig) validate() ConfigErrors {
+	problems := ConfigErrors{}
+
+	if f.TradeMe.Key == "" {
+		problems = append(problems, "trademe.key (TRADEME_API_KEY) not set")
+	}
+	if f.TradeMe.Secret == "" {
+		problems = append(problems, "trademe.secret (TRADEME_API_SECRET) not set")
+	}
+	if f.TradeMe.MaxPages != nil && *f.TradeMe.MaxPages < 0 {
+		problems = append(problems, "trademe.max_pages must be 0 or more")
+	}
+	if f.TradeMe.Environment != "" {
+		if _, ok := TradeMeEnvironments[f.TradeMe.Environment]; !ok {
+			problems = append(problems, "trademe.environment (TRADEME_ENV) must be production or sandbox")
+		}
+	}
+	if f.TradeMe.BaseURL != "" && !isHTTPURL(f.TradeMe.BaseURL) {
+		problems = append(problems, "trademe.base_url (TRADEME_BASE_URL) must be an http(s) URL")
+	}
+	if f.TradeMe.OAuthURL != "" && !isHTTPURL(f.TradeMe.OAuthURL) {
+		problems = append(problems, "trademe.oauth_url (TRADEME_OAUTH_URL) must be an http(s) URL")
+	}
+	if (f.TradeMe.Token == "") != (f.TradeMe.TokenSecret == "") {
+		problems = append(problems, "trademe.token (TRADEME_TOKEN) and trademe.token_secret (TRADEME_TOKEN_SECRET) must be set together")
+	}
+	if f.TradeMe.Since != "" {
+		_, err := parseSince(f.TradeMe.Since)
+		if err != nil {
+			problems = append(problems, "trademe.since (SINCE) "+err.Error())
+		}
+	}
+	if f.TradeMe.PollOverlap != "" {
+		overlap, err := time.ParseDuration(f.TradeMe.PollOverlap)
+		if err != nil || overlap < 0 {
+			problems = append(problems, "trademe.poll_overlap (POLL_OVERLAP) must be a duration like 15m")
+		}
+	}
+	if f.TradeMe.WithdrawnCheckEvery != "" {
+		every, err := time.ParseDuration(f.TradeMe.WithdrawnCheckEvery)
+		if err != nil || every < 0 {
+			problems = append(problems, "trademe.withdrawn_check_every (WITHDRAWN_CHECK_EVERY) must be a duration like 6h")
+		}
+	}
+
+	if f.Google.CacheTTL != "" {
+		ttl, err := time.ParseDuration(f.Google.CacheTTL)
+		if err != nil || ttl < 0 {
+			problems = append(problems, "google.cache_ttl (GOOGLE_CACHE_TTL) must be a duration like 720h")
+		}
+	}
+	if precision := f.Google.CachePrecision; precision != nil && (*precision < 0 || *precision > 6) {
+		problems = append(problems, "google.cache_precision must be between 0 and 6")
+	}
+
+	if f.Chorus.CacheTTL != "" {
+		ttl, err := time.ParseDuration(f.Chorus.CacheTTL)
+		if err != nil || ttl < 0 {
+			problems = append(problems, "chorus.cache_ttl (CHORUS_CACHE_TTL) must be a duration like 720h")
+		}
+	}
+	if f.Chorus.MaxMatchDistance < 0 {
+		problems = append(problems, "chorus.max_match_distance must be 0 or more")
+	}
+	if (f.Chorus.ClientID == "") != (f.Chorus.ClientSecret == "") {
+		problems = append(problems, "chorus.client_id (CHORUS_CLIENT_ID) and chorus.client_secret (CHORUS_CLIENT_SECRET) must be set together")
+	}
+	if f.Chorus.CredentialsURL != "" && !isHTTPURL(f.Chorus.CredentialsURL) {
+		problems = append(problems, "chorus.credentials_url (CHORUS_CREDENTIALS_URL) must be an http(s) URL")
+	}
+	for i, checker := range f.LFCCheckers {
+		problems = append(problems, checker.validate(fmt.Sprintf("lfc_checkers[%d]", i))...)
+	}
+
+	if f.Enrich.Workers < 0 || f.Enrich.ChorusConcurrency < 0 || f.Enrich.GoogleConcurrency < 0 || f.Enrich.TradeMeConcurrency < 0 {
+		problems = append(problems, "enrich: workers and concurrency must be 0 or more")
+	}
+
+	if weights := f.Scoring.Weights; weights != nil {
+		if weights.Price < 0 || weights.Bedrooms < 0 || weights.Bathrooms < 0 || weights.Parking < 0 || weights.Fibre < 0 || weights.Travel < 0 {
+			problems = append(problems, "scoring.weights must be 0 or more")
+		}
+	}
+	scoringDurations := []struct {
+		key   string
+		value string
+	}{
+		{"scoring.travel_max", f.Scoring.TravelMax},
+		{"scoring.digest_every (DIGEST_EVERY)", f.Scoring.DigestEvery},
+		{"scoring.digest_max_age", f.Scoring.DigestMaxAge},
+	}
+	for _, field := range scoringDurations {
+		if field.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(field.value)
+		if err != nil || duration <= 0 {
+			problems = append(problems, field.key+" must be a duration like 24h")
+		}
+	}
+	if f.Scoring.DigestSize < 0 {
+		problems = append(problems, "scoring.digest_size (DIGEST_SIZE) must be 0 or more")
+	}
+
+	destinations := map[string]bool{}
+	for i, destination := range f.Destinations {
+		if destination.Name == "" {
+			problems = append(problems, fmt.Sprintf("destinations[%d]: name not set", i))
+		} else if destinations[destination.Name] {
+			problems = append(problems, fmt.Sprintf("destinations[%d]: duplicate name %s", i, destination.Name))
+		}
+		if destination.Address == "" {
+			problems = append(problems, fmt.Sprintf("destinations[%d]: address not set", i))
+		}
+		problems = append(problems, destination.validate(fmt.Sprintf("destinations[%d]", i))...)
+		destinations[destination.Name] = true
+	}
+
+	problems = append(problems, f.NotifierSettings.validate("notifiers")...)
+
+	for i, rule := range f.Rules {
+		problems = append(problems, rule.validate(fmt.Sprintf("rules[%d]", i))...)
+	}
+	if f.Requirements != nil {
+		problems = append(problems, f.Requirements.validate("requirements", f.Destinations)...)
+	}
+
+	if len(f.Searches) == 0 {
+		problems = append(problems, "searches: at least one search required")
+	}
+	names := map[string]bool{}
+	for i, search := range f.Searches {
+		problems = append(problems, search.validate(i)...)
+		if search.Name != "" && names[search.Name] {
+			problems = append(problems, fmt.Sprintf("searches[%d]: duplicate name %s", i, search.Name))
+		}
+		names[search.Name] = true
+
+		// Search notifier settings fall back to the global ones
+		if search.NotifierSettings != (NotifierSettings{}) {
+			settings := search.resolveNotifierSettings(f.NotifierSettings)
+			problems = append(problems, settings.validate(fmt.Sprintf("searches[%d]", i))...)
+		}
+		if search.Requirements != nil {
+			problems = append(problems, search.Requirements.validate(fmt.Sprintf("searches[%d].requirements", i), f.Destinations)...)
+		}
+	}
+
+	return problems
+}
+
+// isHTTPURL - Absolute http or https URL
+func isHTTPURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// localConfig - Convert the validated file into runtime config
+func (f *FileConfig) localConfig() LocalConfig {
+	c := LocalConfig{
+		Notify:             f.NotifierSettings,
+		GoogleApiToken:     f.Google.APIKey,
+		Destinations:       f.Destinations,
+		TradeMeKey:         f.TradeMe.Key,
+		TradeMeSecret:      f.TradeMe.Secret,
+		TradeMeToken:       f.TradeMe.Token,
+		TradeMeTokenSecret: f.TradeMe.TokenSecret,
+		TradeMeMaxPages:    10,
+		Since:              8 * time.Hour,
+		PollOverlap:        15 * time.Minute,
+
+		EnrichWorkers:      4,
+		ChorusConcurrency:  2,
+		GoogleConcurrency:  4,
+		TradeMeConcurrency: 2,
+		FetchDetails:       f.TradeMe.FetchDetails,
+		NotifyWithdrawn:    f.TradeMe.NotifyWithdrawn,
+		Searches:           f.Searches,
+		Rules:              f.Rules,
+		Requirements:       f.Requirements,
+
+		ScoreWeights:   DefaultScoreWeights,
+		TravelScoreMax: DefaultTravelScoreMax,
+		DigestSize:     10,
+		DigestMaxAge:   7 * 24 * time.Hour,
+
+		WithdrawnCheckEvery: DefaultListingCheckEvery,
+
+		ChorusClientID:       f.Chorus.ClientID,
+		ChorusClientSecret:   f.Chorus.ClientSecret,
+		ChorusCredentialsURL: f.Chorus.CredentialsURL,
+		LFCCheckers:          f.LFCCheckers,
+		TravelCacheTTL:       DefaultTravelCacheTTL,
+		TravelCachePrecision: DefaultTravelCachePrecision,
+		BroadbandCacheTTL:    DefaultBroadbandCacheTTL,
+		ChorusMatchDistance:  DefaultChorusMatchDistance,
+	}
+
+	if f.Enrich.Workers > 0 {
+		c.EnrichWorkers = f.Enrich.Workers
+	}
+	if f.Enrich.ChorusConcurrency > 0 {
+		c.ChorusConcurrency = f.Enrich.ChorusConcurrency
+	}
+	if f.Enrich.GoogleConcurrency > 0 {
+		c.GoogleConcurrency = f.Enrich.GoogleConcurrency
+	}
+	if f.Enrich.TradeMeConcurrency > 0 {
+		c.TradeMeConcurrency = f.Enrich.TradeMeConcurrency
+	}
+
+	// Already validated
+	if f.Google.CacheTTL != "" {
+		c.TravelCacheTTL, _ = time.ParseDuration(f.Google.CacheTTL)
+	}
+	if f.Google.CachePrecision != nil {
+		c.TravelCachePrecision = *f.Google.CachePrecision
+	}
+	if f.Chorus.CacheTTL != "" {
+		c.BroadbandCacheTTL, _ = time.ParseDuration(f.Chorus.CacheTTL)
+	}
+	if f.Chorus.MaxMatchDistance > 0 {
+		c.ChorusMatchDistance = f.Chorus.MaxMatchDistance
+	}
+	if f.TradeMe.Since != "" {
+		c.Since, _ = parseSince(f.TradeMe.Since)
+	}
+	if f.TradeMe.PollOverlap != "" {
+		c.PollOverlap, _ = time.ParseDuration(f.TradeMe.PollOverlap)
+	}
+	if f.TradeMe.WithdrawnCheckEvery != "" {
+		c.WithdrawnCheckEvery, _ = time.ParseDuration(f.TradeMe.WithdrawnCheckEvery)
+	}
+
+	environment := TradeMeEnvironments["production"]
+	if f.TradeMe.Environment != "" {
+		environment = TradeMeEnvironments[f.TradeMe.Environment]
+	}
+	c.TradeMeAPIURL = environment.APIURL
+	c.TradeMeOAuthURL = environment.OAuthURL
+	if f.TradeMe.BaseURL != "" {
+		c.TradeMeAPIURL = strings.TrimSuffix(f.TradeMe.BaseURL, "/")
+	}
+	if f.TradeMe.OAuthURL != "" {
+		c.TradeMeOAuthURL = strings.TrimSuffix(f.TradeMe.OAuthURL, "/")
+	}
+
+	if f.Scoring.Weights != nil {
+		c.ScoreWeights = *f.Scoring.Weights
+	}
+	if f.Scoring.TravelMax != "" {
+		c.TravelScoreMax, _ = time.ParseDuration(f.Scoring.TravelMax)
+	}
+	if f.Scoring.DigestEvery != "" {
+		c.DigestEvery, _ = time.ParseDuration(f.Scoring.DigestEvery)
+	}
+	if f.Scoring.DigestSize > 0 {
+		c.DigestSize = f.Scoring.DigestSize
+	}
+	if f.Scoring.DigestMaxAge != "" {
+		c.DigestMaxAge, _ = time.ParseDuration(f.Scoring.DigestMaxAge)
+	}
+
+	// Cap pages per search so a huge result set can't run away, 0 is unlimited
+	if f.TradeMe.MaxPages != nil {
+		c.TradeMeMaxPages = *f.TradeMe.MaxPages
+	}
+
+	return c
+}
@@ -0,0 +1,63 @@
+package flatfinder
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTradeMeEnvironment(t *testing.T) {
+	tests := []struct {
+		environment string
+		baseURL     string
+		wantAPI     string
+		wantOAuth   string
+	}{
+		{"", "", "https://api.trademe.co.nz/v1", "https://secure.trademe.co.nz/Oauth"},
+		{"sandbox", "", "https://api.tmsandbox.co.nz/v1", "https://secure.tmsandbox.co.nz/Oauth"},
+		{"sandbox", "http://127.0.0.1:8080/v1/", "http://127.0.0.1:8080/v1", "https://secure.tmsandbox.co.nz/Oauth"},
+	}
+	for _, test := range tests {
+		var f FileConfig
+		f.TradeMe.Environment = test.environment
+		f.TradeMe.BaseURL = test.baseURL
+
+		c := f.localConfig()
+		if c.TradeMeAPIURL != test.wantAPI || c.TradeMeOAuthURL != test.wantOAuth {
+			t.Errorf("%q %q: got %s %s", test.environment, test.baseURL, c.TradeMeAPIURL, c.TradeMeOAuthURL)
+		}
+	}
+}
+
+func TestValidateTradeMeEnvironment(t *testing.T) {
+	var f FileConfig
+	f.TradeMe.Key = "key"
+	f.TradeMe.Secret = "secret"
+	f.TradeMe.Environment = "staging"
+	f.TradeMe.BaseURL = "api.trademe.co.nz"
+	f.Searches = []*Search{{Name: "test", Suburbs: "47", BedroomsMin: "1", BedroomsMax: "2", PriceMax: "700", PropertyTypes: "House"}}
+
+	problems := f.validate()
+	want := ConfigErrors{
+		"trademe.environment (TRADEME_ENV) must be production or sandbox",
+		"trademe.base_url (TRADEME_BASE_URL) must be an http(s) URL",
+	}
+	if len(problems) != len(want) || problems[0] != want[0] || problems[1] != want[1] {
+		t.Fatalf("got %q, want %q", problems, want)
+	}
+}
+
+func TestValidateNullRules(t *testing.T) {
+	var f FileConfig
+	err := json.Unmarshal([]byte(`{"rules": [null], "searches": [{"name": "test", "suburbs": "47", "bedrooms_min": "1", "bedrooms_max": "2", "price_max": "700", "property_type": "House", "rules": [{"all": [null]}]}]}`), &f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.TradeMe.Key = "key"
+	f.TradeMe.Secret = "secret"
+
+	problems := f.validate()
+	want := ConfigErrors{"rules[0]: can't be null", "test: rules[0].all[0]: can't be null"}
+	if len(problems) != len(want) || problems[0] != want[0] || problems[1] != want[1] {
+		t.Fatalf("got %q, want %q", problems, want)
+	}
+}
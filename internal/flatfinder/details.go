@@ -0,0 +1,183 @@
+package flatfinder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TradeMeListingDetails - The parts of the listing details endpoint search leaves out
+type TradeMeListingDetails struct {
+	Body       string             `json:"Body"`
+	Photos     []TradeMePhoto     `json:"Photos"`
+	Attributes []TradeMeAttribute `json:"Attributes"`
+	OpenHomes  []TradeMeOpenHome  `json:"OpenHomes"`
+
+	// Usually empty from search, filled from the details or their attributes
+	Amenities       string `json:"Amenities"`
+	IdealTenant     string `json:"IdealTenant"`
+	MaxTenants      int    `json:"MaxTenants"`
+	BestContactTime string `json:"BestContactTime"`
+}
+
+// TradeMePhoto - Every size Trade Me has of a photo
+type TradeMePhoto struct {
+	Key   int64 `json:"Key"`
+	Value struct {
+		Thumbnail string `json:"Thumbnail"`
+		List      string `json:"List"`
+		Medium    string `json:"Medium"`
+		Gallery   string `json:"Gallery"`
+		Large     string `json:"Large"`
+		FullSize  string `json:"FullSize"`
+		PhotoID   int64  `json:"PhotoId"`
+	} `json:"Value"`
+}
+
+// TradeMeAttribute - Property attributes like "Ideal tenants" or "In the area"
+type TradeMeAttribute struct {
+	Name        string `json:"Name"`
+	DisplayName string `json:"DisplayName"`
+	Value       string `json:"Value"`
+}
+
+// TradeMeOpenHome - Start and end as Trade Me dates
+type TradeMeOpenHome struct {
+	Start string `json:"Start"`
+	End   string `json:"End"`
+}
+
+// fetchListingDetails - Call the listing details endpoint for one listing
+func (c *LocalConfig) fetchListingDetails(ctx context.Context, listingID int64) (*TradeMeListingDetails, error) {
+	req, err := c.newTrademeRequest(ctx, c.TradeMeAPIURL+fmt.Sprintf(TradeMeListingPath, listingID))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.trademeClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Invalid response from API: " + resp.Status)
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var details TradeMeListingDetails
+	err = json.Unmarshal(bodyBytes, &details)
+	if err != nil {
+		return nil, err
+	}
+	details.fillFromAttributes()
+
+	return &details, nil
+}
+
+// fillFromAttributes - Property fields often only come through as attributes
+func (d *TradeMeListingDetails) fillFromAttributes() {
+	for _, attribute := range d.Attributes {
+		value := strings.TrimSpace(attribute.Value)
+		switch strings.ToLower(attribute.Name) {
+		case "amenities":
+			if d.Amenities == "" {
+				d.Amenities = value
+			}
+		case "ideal_tenant", "ideal_tenants":
+			if d.IdealTenant == "" {
+				d.IdealTenant = value
+			}
+		case "max_tenants", "maximum_tenants":
+			if d.MaxTenants == 0 {
+				d.MaxTenants, _ = strconv.Atoi(value)
+			}
+		case "best_contact_time":
+			if d.BestContactTime == "" {
+				d.BestContactTime = value
+			}
+		}
+	}
+}
+
+// apply - Fill in what search left out of a listing
+func (d *TradeMeListingDetails) apply(listing *TradeMeListing) {
+	if listing.Amenities == "" {
+		listing.Amenities = d.Amenities
+	}
+	if listing.IdealTenant == "" {
+		listing.IdealTenant = d.IdealTenant
+	}
+	if listing.MaxTenants == 0 {
+		listing.MaxTenants = d.MaxTenants
+	}
+	if listing.BestContactTime == "" {
+		listing.BestContactTime = d.BestContactTime
+	}
+
+	if len(d.Photos) > 0 {
+		listing.PhotoUrls = []string{}
+		for _, photo := range d.Photos {
+			if photo.Value.FullSize != "" {
+				listing.PhotoUrls = append(listing.PhotoUrls, photo.Value.FullSize)
+			} else if photo.Value.Large != "" {
+				listing.PhotoUrls = append(listing.PhotoUrls, photo.Value.Large)
+			}
+		}
+	}
+
+	if len(d.OpenHomes) > 0 {
+		listing.OpenHomes = []interface{}{}
+		for _, openHome := range d.OpenHomes {
+			listing.OpenHomes = append(listing.OpenHomes, openHome)
+		}
+	}
+}
+
+// openHomeTimes - Upcoming open homes, formatted for people
+func (d *TradeMeListingDetails) openHomeTimes() []string {
+	times := []string{}
+	for _, openHome := range d.OpenHomes {
+		start, err := parseTrademeDate(openHome.Start)
+		if err != nil || start.Before(time.Now()) {
+			continue
+		}
+		end, err := parseTrademeDate(openHome.End)
+		if err != nil {
+			continue
+		}
+
+		times = append(times, start.Local().Format("Mon 2 Jan 3:04pm")+" - "+end.Local().Format("3:04pm"))
+	}
+
+	return times
+}
+
+// fetchDetails - Look up details once per listing, kept in the store after that
+func (c *LocalConfig) fetchDetails(ctx context.Context, stored *StoredListing) {
+	if !c.FetchDetails || !stored.DetailsFetchedAt.IsZero() {
+		return
+	}
+
+	c.trademeLimit.run(func() {
+		details, err := c.fetchListingDetails(ctx, stored.Listing.ListingID)
+		if err != nil {
+			log.Printf("Failed to fetch details for listing %d: %s", stored.Listing.ListingID, err)
+			return
+		}
+
+		stored.Details = details
+		stored.DetailsFetchedAt = time.Now()
+	})
+}
@@ -0,0 +1,114 @@
+package flatfinder
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// DigestNotifier - A notifier that can send a ranked list in one go
+type DigestNotifier interface {
+	NotifyDigest(ctx context.Context, search string, listings []EnrichedListing) error
+}
+
+// sendDigests - Rank each search's current listings and send the top ones
+func (c *LocalConfig) sendDigests(ctx context.Context) {
+	if c.DigestEvery == 0 {
+		return
+	}
+
+	for _, search := range c.Searches {
+		if ctx.Err() != nil {
+			return
+		}
+
+		lastDigest, err := c.Store.LastDigest(search.Name)
+		if err != nil {
+			log.Printf("[%s] %s", search.Name, err)
+			continue
+		}
+		if time.Since(lastDigest) < c.DigestEvery {
+			continue
+		}
+
+		listings, err := c.digestListings(search)
+		if err != nil {
+			log.Printf("[%s] %s", search.Name, err)
+			continue
+		}
+		if len(listings) > 0 {
+			c.notifyDigest(ctx, search, listings)
+		}
+
+		err = c.Store.SetLastDigest(search.Name, time.Now())
+		if err != nil {
+			log.Printf("[%s] %s", search.Name, err)
+		}
+	}
+}
+
+// digestListings - Open listings sent to the search, best score first
+func (c *LocalConfig) digestListings(search *Search) ([]EnrichedListing, error) {
+	stored, err := c.Store.OpenNotifiedListings()
+	if err != nil {
+		return nil, err
+	}
+
+	listings := []EnrichedListing{}
+	for _, listing := range stored {
+		status, ok := listing.Notifications[search.Name]
+		if !ok || status.LowPriority != "" {
+			continue
+		}
+
+		// Imported from flatfinder.json and not filled in by a listing check yet
+		if listing.Listing.Title == "" {
+			continue
+		}
+		if c.DigestMaxAge > 0 && time.Since(listing.FirstSeen) > c.DigestMaxAge {
+			continue
+		}
+
+		enriched := listing.enriched()
+		enriched.Score = c.scoreListing(search, enriched)
+		listings = append(listings, enriched)
+	}
+
+	// Ties go to the newest
+	sort.SliceStable(listings, func(i, j int) bool {
+		if listings[i].Score != listings[j].Score {
+			return listings[i].Score > listings[j].Score
+		}
+		return listings[i].ListingID > listings[j].ListingID
+	})
+
+	if c.DigestSize > 0 && len(listings) > c.DigestSize {
+		listings = listings[:c.DigestSize]
+	}
+
+	return listings, nil
+}
+
+// notifyDigest - One message where the notifier supports it, otherwise each listing in order
+func (c *LocalConfig) notifyDigest(ctx context.Context, search *Search, listings []EnrichedListing) {
+	log.Printf("[%s] Sending digest of %d listings", search.Name, len(listings))
+
+	for _, notifier := range search.Notifiers {
+		var err error
+		if digest, ok := notifier.(DigestNotifier); ok {
+			err = digest.NotifyDigest(ctx, search.Name, listings)
+		} else {
+			for _, listing := range listings {
+				listing.Alert = AlertDigest
+				if err = notifier.Notify(ctx, listing); err != nil {
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			log.Printf("[%s] %s digest failed: %s", search.Name, notifier.Name(), err)
+		}
+	}
+}
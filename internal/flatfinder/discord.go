@@ -1,53 +1,59 @@
 package flatfinder
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
 
 	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/rest"
 	"github.com/disgoorg/disgo/webhook"
 	"github.com/disgoorg/snowflake/v2"
 )
 
-// Load discord client
-func (c *LocalConfig) initDiscord() {
+// DiscordNotifier - Posts listings as embeds to a Discord webhook
+type DiscordNotifier struct {
+	Tag    string
+	client webhook.Client
+}
+
+// newDiscordNotifier - Load discord client
+func newDiscordNotifier(webhookURL string, tag string) (*DiscordNotifier, error) {
 	// Webhook URL splitting
-	webhookString := strings.ReplaceAll(c.DiscordWebhook, "https://discord.com/api/webhooks/", "")
+	webhookString := strings.ReplaceAll(webhookURL, "https://discord.com/api/webhooks/", "")
 	webhookParts := strings.Split(webhookString, "/")
 	if len(webhookParts) != 2 {
-		log.Fatal("Invalid DISCORD_WEBHOOK")
+		return nil, errors.New("Invalid DISCORD_WEBHOOK")
 	}
 
 	// Convert snowflakeID to uint64
 	i, err := strconv.ParseInt(webhookParts[0], 10, 64)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	// Start client!
-	client := webhook.New(snowflake.ID(i), webhookParts[1])
-	c.DiscordClient = client
+	client := webhook.New(
+		snowflake.ID(i),
+		webhookParts[1],
+		webhook.WithRestClientConfigOpts(rest.WithHTTPClient(notifierHTTP)),
+	)
 
 	log.Print("Discord client loaded succesfully")
+	return &DiscordNotifier{Tag: tag, client: client}, nil
 }
 
-// sendEmbeddedMessage - Build an embedded message from listing data
-func (c *LocalConfig) sendEmbeddedMessage(listing TradeMeListing) {
-	log.Printf("New listing: %s", listing.Title)
-
-	hasFibre, currentConn := getAvailableSpeeds(
-		fmt.Sprintf(
-			"%s, %s, %s",
-			strings.TrimSpace(listing.Address),
-			strings.TrimSpace(listing.Suburb),
-			strings.TrimSpace(listing.Region),
-		),
-	)
+func (n *DiscordNotifier) Name() string {
+	return "discord"
+}
 
+// Notify - Build an embedded message from listing data
+func (n *DiscordNotifier) Notify(ctx context.Context, listing EnrichedListing) error {
 	embed := discord.NewEmbedBuilder().
-		SetTitle(listing.Title).
+		SetTitle(alertTitle(listing)).
 		SetURL(fmt.Sprintf("https://trademe.co.nz/%d", listing.ListingID)).
 		SetColor(1127128).
 		SetImage(listing.PictureHref).
@@ -62,29 +68,75 @@ func (c *LocalConfig) sendEmbeddedMessage(listing TradeMeListing) {
 			true,
 		).
 		AddField("Bedrooms", fmt.Sprintf("%d", listing.Bedrooms), true).
-		AddField("Fibre Avail", hasFibre, false).
-		AddField("Current Connection", currentConn, false)
+		AddField("Score", fmt.Sprintf("%.0f/100", listing.Score), true).
+		AddField("Fibre Avail", listing.Broadband.fibreSummary(), false).
+		AddField("Current Connection", listing.Broadband.connectionSummary(), false)
+
+	// Old and new price for drops and relists
+	if change := priceChange(listing); change != "" {
+		embed = embed.AddField("Price", change, false)
+	}
+	if listing.LowPriority != "" {
+		embed = embed.AddField("Missed Requirement", listing.LowPriority, false)
+	}
+	if listing.Alert == AlertRelisted {
+		embed = embed.AddField("Previous Listing", fmt.Sprintf("https://trademe.co.nz/%d", listing.PreviousListingID), false)
+	}
 
 	// Tag if required
-	if c.DiscordTag != "" {
-		embed.SetDescription(c.DiscordTag)
+	if n.Tag != "" {
+		embed.SetDescription(n.Tag)
 	}
 
-	// Only add address if token set
-	if c.GoogleApiToken != "" && c.GoogleLocation1 != "" {
-		distance := c.getDistanceFromAddress(c.GoogleLocation1, listing.GeographicLocation.Latitude, listing.GeographicLocation.Longitude)
-		embed = embed.AddField(fmt.Sprintf("Walking distance to %s", c.GoogleLocation1), distance, false)
+	// Only there with fetch_details on
+	if listing.Details != nil {
+		if body := strings.TrimSpace(listing.Details.Body); body != "" {
+			embed = embed.AddField("Description", truncate(body, 500), false)
+		}
+		if openHomes := listing.Details.openHomeTimes(); len(openHomes) > 0 {
+			embed = embed.AddField("Open Homes", strings.Join(openHomes, "\n"), false)
+		}
 	}
 
-	if c.GoogleApiToken != "" && c.GoogleLocation2 != "" {
-		distance := c.getDistanceFromAddress(c.GoogleLocation2, listing.GeographicLocation.Latitude, listing.GeographicLocation.Longitude)
-		embed = embed.AddField(fmt.Sprintf("Walking distance to %s", c.GoogleLocation2), distance, false)
+	for _, travelTime := range listing.TravelTimes {
+		embed = embed.AddField(fmt.Sprintf("%s to %s", travelModeLabel(travelTime.Mode), travelTime.Destination), travelTime.Summary, false)
 	}
 
 	embeds := []discord.Embed{}
 	embeds = append(embeds, embed.Build())
-	_, err := c.DiscordClient.CreateEmbeds(embeds)
-	if err != nil {
-		log.Print(err)
+	_, err := n.client.CreateEmbeds(embeds, rest.WithCtx(ctx))
+	return err
+}
+
+// NotifyDigest - One embed ranking the listings, best first
+func (n *DiscordNotifier) NotifyDigest(ctx context.Context, search string, listings []EnrichedListing) error {
+	lines := []string{}
+	for i, listing := range listings {
+		lines = append(lines, fmt.Sprintf(
+			"%d. **%.0f** [%s](https://trademe.co.nz/%d) - %s, %s",
+			i+1,
+			listing.Score,
+			listing.Title,
+			listing.ListingID,
+			listing.PriceDisplay,
+			listing.Address,
+		))
+	}
+
+	embed := discord.NewEmbedBuilder().
+		SetTitle(fmt.Sprintf("Top %d listings: %s", len(listings), search)).
+		SetColor(1127128).
+		SetDescription(truncate(strings.Join(lines, "\n"), 4096))
+
+	_, err := n.client.CreateEmbeds([]discord.Embed{embed.Build()}, rest.WithCtx(ctx))
+	return err
+}
+
+// travelModeLabel - "Walking", "Driving"... older listings have no mode and were walking
+func travelModeLabel(mode string) string {
+	if mode == "" {
+		mode = DefaultTravelMode
 	}
+
+	return strings.ToUpper(mode[:1]) + mode[1:]
 }
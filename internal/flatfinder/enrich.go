@@ -0,0 +1,61 @@
+package flatfinder
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// enrichListing - Look up broadband and travel times for a listing, with an error if any
+// lookup failed rather than answered
+func (c *LocalConfig) enrichListing(ctx context.Context, listing TradeMeListing) (EnrichedListing, error) {
+	enriched := EnrichedListing{TradeMeListing: listing}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed error
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.chorusLimit.run(func() {
+			broadband, err := c.getBroadband(ctx, listing)
+			enriched.Broadband = broadband
+			if err != nil {
+				mu.Lock()
+				failed = err
+				mu.Unlock()
+			}
+		})
+	}()
+
+	// Only add travel times if token set
+	if c.GoogleApiToken != "" {
+		// One call per mode, each group fills its own destinations
+		enriched.TravelTimes = make([]TravelTime, len(c.Destinations))
+		for _, group := range groupDestinations(c.Destinations) {
+			wg.Add(1)
+			go func(group *travelGroup) {
+				defer wg.Done()
+				c.googleLimit.run(func() {
+					travelTimes, err := c.getTravelTimes(ctx, group, listing.GeographicLocation.Latitude, listing.GeographicLocation.Longitude)
+					if err != nil {
+						log.Print(err)
+						mu.Lock()
+						failed = err
+						mu.Unlock()
+					}
+					for n, i := range group.indexes {
+						if err != nil {
+							enriched.TravelTimes[i] = TravelTime{Destination: c.Destinations[i].Name, Mode: group.mode, Summary: "UNKNOWN"}
+							continue
+						}
+						enriched.TravelTimes[i] = travelTimes[n]
+					}
+				})
+			}(group)
+		}
+	}
+
+	wg.Wait()
+	return enriched, failed
+}
@@ -0,0 +1,188 @@
+package flatfinder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTrademe - Serves recorded responses from testdata/trademe
+type fakeTrademe struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	searches []url.Values
+	listings map[string]int
+
+	// Fixture name -> replacements made before serving it
+	edits map[string]*strings.Replacer
+}
+
+var fakeListingPath = regexp.MustCompile(`^/v1/Listings/(\d+)\.json$`)
+
+// newFakeTrademe - Start a fake API, closed when the test ends
+func newFakeTrademe(t *testing.T) *fakeTrademe {
+	fake := &fakeTrademe{listings: map[string]int{}, edits: map[string]*strings.Replacer{}}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serveHTTP))
+	t.Cleanup(fake.Close)
+
+	return fake
+}
+
+func (f *fakeTrademe) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	// Same check as the real API, anything unsigned is rejected
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "OAuth ") || !strings.Contains(auth, `oauth_signature_method="HMAC-SHA1"`) || !strings.Contains(auth, "oauth_signature=") {
+		http.Error(w, `{"ErrorDescription":"Invalid signature"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/v1"+TradeMeSearchPath:
+		f.mu.Lock()
+		f.searches = append(f.searches, r.URL.Query())
+		f.mu.Unlock()
+
+		// Past the recorded pages the API returns an empty list
+		page := r.URL.Query().Get("page")
+		if !f.serveFixture(w, "search_page"+page+".json") {
+			fmt.Fprintf(w, `{"TotalCount":3,"Page":%s,"PageSize":2,"List":[]}`, page)
+		}
+	case fakeListingPath.MatchString(r.URL.Path):
+		id := fakeListingPath.FindStringSubmatch(r.URL.Path)[1]
+		f.mu.Lock()
+		f.listings[id]++
+		f.mu.Unlock()
+
+		if !f.serveFixture(w, "listing_"+id+".json") {
+			http.NotFound(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveFixture - Write a recorded response, false if there isn't one
+func (f *fakeTrademe) serveFixture(w http.ResponseWriter, name string) bool {
+	data, err := os.ReadFile(filepath.Join("testdata", "trademe", name))
+	if err != nil {
+		return false
+	}
+
+	f.mu.Lock()
+	if edit, ok := f.edits[name]; ok {
+		data = []byte(edit.Replace(string(data)))
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+	return true
+}
+
+// edit - Serve a fixture with old, new string pairs replaced from now on
+func (f *fakeTrademe) edit(name string, oldnew ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.edits[name] = strings.NewReplacer(oldnew...)
+}
+
+// searchQueries - Query strings of every search request so far
+func (f *fakeTrademe) searchQueries() []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]url.Values{}, f.searches...)
+}
+
+// listingRequests - How many times a listing's details were asked for
+func (f *fakeTrademe) listingRequests(id string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.listings[id]
+}
+
+// recordingNotifier - Keeps everything it's sent
+type recordingNotifier struct {
+	mu       sync.Mutex
+	listings []EnrichedListing
+}
+
+func (n *recordingNotifier) Name() string {
+	return "recording"
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, listing EnrichedListing) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.listings = append(n.listings, listing)
+	return nil
+}
+
+// sent - Titles of everything sent, in order
+func (n *recordingNotifier) sent() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	titles := []string{}
+	for _, listing := range n.listings {
+		titles = append(titles, listing.Title)
+	}
+
+	return titles
+}
+
+// newTestConfig - Config pointed at the fake with a fresh store and one search
+func newTestConfig(t *testing.T, fake *fakeTrademe) (*LocalConfig, *recordingNotifier) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), "flatfinder.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	// Broadband lookups go to Chorus, fail them fast rather than leave the sandbox
+	realChorus := chorusHTTP
+	chorusHTTP = &http.Client{Transport: failingTransport{}}
+	t.Cleanup(func() { chorusHTTP = realChorus })
+
+	notifier := &recordingNotifier{}
+	c := &LocalConfig{
+		TradeMeKey:         "key",
+		TradeMeSecret:      "secret",
+		TradeMeAPIURL:      fake.URL + "/v1",
+		TradeMeMaxPages:    10,
+		Since:              8 * time.Hour,
+		PollOverlap:        15 * time.Minute,
+		EnrichWorkers:      2,
+		TradeMeConcurrency: 2,
+		Store:              store,
+		Searches: []*Search{{
+			Name:      "test",
+			Suburbs:   "47,52",
+			PriceMax:  "700",
+			Notifiers: []Notifier{notifier},
+		}},
+	}
+	c.initPipeline()
+
+	return c, notifier
+}
+
+// failingTransport - Every request fails without touching the network
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("no network in tests: %s", req.URL.Host)
+}
@@ -1,14 +1,27 @@
 package flatfinder
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// GoogleDistanceMatrixURL - Distance Matrix endpoint, overridable for tests
+var GoogleDistanceMatrixURL = "https://maps.googleapis.com/maps/api/distancematrix/json"
+
+// TravelModes - Modes the Distance Matrix API supports
+var TravelModes = []string{"walking", "driving", "transit", "bicycling"}
+
+// DefaultTravelMode - Used for destinations without a mode
+var DefaultTravelMode = "walking"
+
 type GoogleMapsDistanceMatrixResponse struct {
 	DestinationAddresses []string `json:"destination_addresses"`
 	OriginAddresses      []string `json:"origin_addresses"`
@@ -25,64 +38,176 @@ type GoogleMapsDistanceMatrixResponse struct {
 			Status string `json:"status"`
 		} `json:"elements"`
 	} `json:"rows"`
-	Status string `json:"status"`
+	Status       string `json:"status"`
+	ErrorMessage string `json:"error_message"`
+}
+
+// travelMode - The destination's mode, walking if not set
+func (d Destination) travelMode() string {
+	if d.Mode == "" {
+		return DefaultTravelMode
+	}
+
+	return strings.ToLower(d.Mode)
 }
 
-// getDistanceFromAddress - Return distance between 2 points
-func (c *LocalConfig) getDistanceFromAddress(address string, toLat float64, toLong float64) string {
+// validate - Known mode, and an arrival time only where Google allows one
+func (d Destination) validate(prefix string) []string {
+	problems := []string{}
 
-	mapsURL := fmt.Sprintf(
-		"https://maps.googleapis.com/maps/api/distancematrix/json?units=metric&mode=%s&origins=%f,%f&destinations=%s&key=%s",
-		"walking",
-		toLat,
-		toLong,
-		url.QueryEscape(address),
-		c.GoogleApiToken,
-	)
+	known := false
+	for _, mode := range TravelModes {
+		known = known || d.travelMode() == mode
+	}
+	if !known {
+		problems = append(problems, fmt.Sprintf("%s: mode must be one of %s", prefix, strings.Join(TravelModes, ", ")))
+	}
 
-	client := http.Client{}
-	req, err := http.NewRequest("GET", mapsURL, nil)
+	if d.ArrivalTime != "" {
+		if _, err := time.Parse("15:04", d.ArrivalTime); err != nil {
+			problems = append(problems, prefix+": arrival_time must be a time like 08:30")
+		}
+		if d.travelMode() != "transit" {
+			problems = append(problems, prefix+": arrival_time only works with mode transit")
+		}
+	}
+
+	return problems
+}
+
+// nextArrival - The next weekday at clock, local time
+func nextArrival(clock string, now time.Time) (time.Time, error) {
+	at, err := time.Parse("15:04", clock)
 	if err != nil {
-		log.Print(err)
-		return "UNKNOWN"
+		return time.Time{}, err
+	}
+
+	arrival := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	for !arrival.After(now) || arrival.Weekday() == time.Saturday || arrival.Weekday() == time.Sunday {
+		arrival = arrival.AddDate(0, 0, 1)
+	}
+
+	return arrival, nil
+}
+
+// travelGroup - Destinations that can share one Distance Matrix call
+type travelGroup struct {
+	mode        string
+	arrivalTime string
+
+	// Index into the destinations for each one in the group
+	indexes []int
+}
+
+// groupDestinations - One group per mode and arrival time, in config order
+func groupDestinations(destinations []Destination) []*travelGroup {
+	groups := []*travelGroup{}
+	byKey := map[string]*travelGroup{}
+
+	for i, destination := range destinations {
+		key := destination.travelMode() + "|" + destination.ArrivalTime
+		group, ok := byKey[key]
+		if !ok {
+			group = &travelGroup{mode: destination.travelMode(), arrivalTime: destination.ArrivalTime}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.indexes = append(group.indexes, i)
+	}
+
+	return groups
+}
+
+// getTravelTimes - Travel from a listing to every destination in the group, cached ones
+// come from the store and the rest from one call
+func (c *LocalConfig) getTravelTimes(ctx context.Context, group *travelGroup, lat float64, long float64) ([]TravelTime, error) {
+	travelTimes := make([]TravelTime, len(group.indexes))
+	keys := make([]string, len(group.indexes))
+	missing := []int{}
+	for n, i := range group.indexes {
+		keys[n] = c.travelCacheKey(c.Destinations[i], lat, long)
+		if cached, ok := c.cachedTravelTime(keys[n]); ok {
+			cached.Destination = c.Destinations[i].Name
+			travelTimes[n] = cached
+			continue
+		}
+		missing = append(missing, n)
+	}
+	if len(missing) == 0 {
+		return travelTimes, nil
+	}
+
+	addresses := []string{}
+	for _, n := range missing {
+		addresses = append(addresses, c.Destinations[group.indexes[n]].Address)
+	}
+
+	queryParams := url.Values{}
+	queryParams.Set("units", "metric")
+	queryParams.Set("mode", group.mode)
+	queryParams.Set("origins", fmt.Sprintf("%f,%f", lat, long))
+	queryParams.Set("destinations", strings.Join(addresses, "|"))
+	queryParams.Set("key", c.GoogleApiToken)
+	if group.arrivalTime != "" {
+		arrival, err := nextArrival(group.arrivalTime, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		queryParams.Set("arrival_time", strconv.FormatInt(arrival.Unix(), 10))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", GoogleDistanceMatrixURL+"?"+queryParams.Encode(), nil)
+	if err != nil {
+		return nil, err
 	}
 
 	// Do the request
-	resp, err := client.Do(req)
+	resp, err := googleHTTP.Do(req)
 	if err != nil {
-		log.Print(err)
-		return "UNKNOWN"
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		bodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Print(err)
-			return "UNKNOWN"
-		}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Maps API error: " + resp.Status)
+	}
 
-		// Decode JSON
-		var mapsResult GoogleMapsDistanceMatrixResponse
-		err = json.Unmarshal(bodyBytes, &mapsResult)
-		if err != nil {
-			log.Print(err)
-			return "UNKNOWN"
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode JSON
+	var mapsResult GoogleMapsDistanceMatrixResponse
+	err = json.Unmarshal(bodyBytes, &mapsResult)
+	if err != nil {
+		return nil, err
+	}
+	if mapsResult.Status != "OK" {
+		return nil, fmt.Errorf("Maps API error: %s %s", mapsResult.Status, mapsResult.ErrorMessage)
+	}
+
+	// One origin, so one row with an element per destination asked for
+	for e, n := range missing {
+		travelTime := TravelTime{
+			Destination: c.Destinations[group.indexes[n]].Name,
+			Mode:        group.mode,
+			Summary:     "N/A",
 		}
 
-		dist := "N/A"
-		time := "N/A"
-		for _, rows := range mapsResult.Rows {
-			for _, element := range rows.Elements {
-				dist = element.Distance.Text
-				time = element.Duration.Text
+		if len(mapsResult.Rows) > 0 && e < len(mapsResult.Rows[0].Elements) {
+			element := mapsResult.Rows[0].Elements[e]
+			if element.Status == "OK" {
+				travelTime.Summary = fmt.Sprintf("%s (%s)", element.Distance.Text, element.Duration.Text)
+				travelTime.Duration = time.Duration(element.Duration.Value) * time.Second
+
+				// Only real answers are cached, so failures get another go next time
+				c.cacheTravelTime(keys[n], travelTime)
 			}
 		}
 
-		return fmt.Sprintf("%s (%s)", dist, time)
-	} else {
-		log.Printf("Maps API error: %s", resp.Status)
+		travelTimes[n] = travelTime
 	}
 
-	return "UNKNOWN"
+	return travelTimes, nil
 }
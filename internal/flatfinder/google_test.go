@@ -0,0 +1,215 @@
+package flatfinder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDistanceMatrix - Answers every destination with minutes from its position in the
+// list and records each query
+type fakeDistanceMatrix struct {
+	mu      sync.Mutex
+	queries []url.Values
+}
+
+func newFakeDistanceMatrix(t *testing.T) *fakeDistanceMatrix {
+	fake := &fakeDistanceMatrix{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		fake.queries = append(fake.queries, r.URL.Query())
+		fake.mu.Unlock()
+
+		elements := []string{}
+		for i := range strings.Split(r.URL.Query().Get("destinations"), "|") {
+			elements = append(elements, fmt.Sprintf(`{"status":"OK","distance":{"text":"%d km","value":%d},"duration":{"text":"%d mins","value":%d}}`, i+1, (i+1)*1000, (i+1)*10, (i+1)*600))
+		}
+		fmt.Fprintf(w, `{"status":"OK","rows":[{"elements":[%s]}]}`, strings.Join(elements, ","))
+	}))
+	t.Cleanup(server.Close)
+
+	realURL := GoogleDistanceMatrixURL
+	GoogleDistanceMatrixURL = server.URL
+	t.Cleanup(func() { GoogleDistanceMatrixURL = realURL })
+
+	realChorus := chorusHTTP
+	chorusHTTP = &http.Client{Transport: failingTransport{}}
+	t.Cleanup(func() { chorusHTTP = realChorus })
+
+	return fake
+}
+
+// calls - Queries so far
+func (f *fakeDistanceMatrix) calls() []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]url.Values{}, f.queries...)
+}
+
+func TestEnrichTravelTimes(t *testing.T) {
+	fake := newFakeDistanceMatrix(t)
+
+	c := &LocalConfig{
+		GoogleApiToken: "token",
+		Destinations: []Destination{
+			{Name: "Work", Address: "1 Lambton Quay", Mode: "transit", ArrivalTime: "08:30"},
+			{Name: "Gym", Address: "2 Cuba Street"},
+			{Name: "Partner", Address: "3 Willis Street", Mode: "transit", ArrivalTime: "08:30"},
+			{Name: "Beach", Address: "4 Oriental Parade", Mode: "walking"},
+		},
+	}
+	c.initPipeline()
+
+	enriched, _ := c.enrichListing(context.Background(), TradeMeListing{Address: "12 Cuba Street"})
+
+	// Transit at 08:30 and walking, so two calls
+	queries := fake.calls()
+	if len(queries) != 2 {
+		t.Fatalf("%d calls, want 2", len(queries))
+	}
+	for _, query := range queries {
+		switch query.Get("mode") {
+		case "transit":
+			if query.Get("destinations") != "1 Lambton Quay|3 Willis Street" || query.Get("arrival_time") == "" {
+				t.Errorf("transit call %s", query.Encode())
+			}
+		case "walking":
+			if query.Get("destinations") != "2 Cuba Street|4 Oriental Parade" || query.Get("arrival_time") != "" {
+				t.Errorf("walking call %s", query.Encode())
+			}
+		default:
+			t.Errorf("unexpected call %s", query.Encode())
+		}
+	}
+
+	want := []TravelTime{
+		{Destination: "Work", Mode: "transit", Summary: "1 km (10 mins)", Duration: 10 * time.Minute},
+		{Destination: "Gym", Mode: "walking", Summary: "1 km (10 mins)", Duration: 10 * time.Minute},
+		{Destination: "Partner", Mode: "transit", Summary: "2 km (20 mins)", Duration: 20 * time.Minute},
+		{Destination: "Beach", Mode: "walking", Summary: "2 km (20 mins)", Duration: 20 * time.Minute},
+	}
+	for i := range want {
+		if enriched.TravelTimes[i] != want[i] {
+			t.Errorf("travel time %d: got %+v, want %+v", i, enriched.TravelTimes[i], want[i])
+		}
+	}
+}
+
+func TestTravelTimeCache(t *testing.T) {
+	fake := newFakeDistanceMatrix(t)
+
+	cache, err := OpenCache(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	c := &LocalConfig{
+		GoogleApiToken: "token",
+		Destinations: []Destination{
+			{Name: "Work", Address: "1 Lambton Quay"},
+			{Name: "Gym", Address: "2 Cuba Street"},
+		},
+		Cache:                cache,
+		TravelCacheTTL:       time.Hour,
+		TravelCachePrecision: 3,
+	}
+	c.initPipeline()
+
+	listing := TradeMeListing{}
+	listing.GeographicLocation.Latitude = -41.2862
+	listing.GeographicLocation.Longitude = 174.7762
+
+	first, _ := c.enrichListing(context.Background(), listing)
+	if len(fake.calls()) != 1 {
+		t.Fatalf("%d calls, want 1", len(fake.calls()))
+	}
+
+	// Same place again, and a neighbour that rounds the same, come from the cache
+	second, _ := c.enrichListing(context.Background(), listing)
+	listing.GeographicLocation.Latitude = -41.2864
+	c.enrichListing(context.Background(), listing)
+	if len(fake.calls()) != 1 {
+		t.Fatalf("%d calls after cached lookups, want 1", len(fake.calls()))
+	}
+	for i := range first.TravelTimes {
+		if first.TravelTimes[i] != second.TravelTimes[i] {
+			t.Errorf("cached %+v, want %+v", second.TravelTimes[i], first.TravelTimes[i])
+		}
+	}
+
+	// A new destination only asks for itself
+	c.Destinations = append(c.Destinations, Destination{Name: "Beach", Address: "4 Oriental Parade"})
+	third, _ := c.enrichListing(context.Background(), listing)
+	calls := fake.calls()
+	if len(calls) != 2 || calls[1].Get("destinations") != "4 Oriental Parade" {
+		t.Fatalf("calls %v, want one more for the new destination", calls)
+	}
+	if third.TravelTimes[2].Destination != "Beach" || third.TravelTimes[2].Duration != 10*time.Minute {
+		t.Errorf("new destination %+v", third.TravelTimes[2])
+	}
+
+	// Expired results are fetched again and pruned
+	c.TravelCacheTTL = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	c.enrichListing(context.Background(), listing)
+	if len(fake.calls()) != 3 {
+		t.Fatalf("%d calls after expiry, want 3", len(fake.calls()))
+	}
+	time.Sleep(time.Millisecond)
+	pruned, err := cache.PruneTravelTimes(c.TravelCacheTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 3 {
+		t.Errorf("pruned %d, want 3", pruned)
+	}
+}
+
+func TestNextArrival(t *testing.T) {
+	tests := []struct {
+		now  string
+		want string
+	}{
+		{"2026-10-14 07:00", "2026-10-14 08:30"}, // Wednesday morning, later today
+		{"2026-10-14 09:00", "2026-10-15 08:30"}, // Wednesday, already past
+		{"2026-10-16 09:00", "2026-10-19 08:30"}, // Friday, skips the weekend
+		{"2026-10-17 07:00", "2026-10-19 08:30"}, // Saturday
+	}
+	for _, test := range tests {
+		now, _ := time.ParseInLocation("2006-01-02 15:04", test.now, time.Local)
+		got, err := nextArrival("08:30", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Format("2006-01-02 15:04") != test.want {
+			t.Errorf("%s: got %s, want %s", test.now, got.Format("2006-01-02 15:04"), test.want)
+		}
+	}
+}
+
+func TestValidateDestination(t *testing.T) {
+	tests := []struct {
+		destination Destination
+		want        int
+	}{
+		{Destination{Mode: "transit", ArrivalTime: "08:30"}, 0},
+		{Destination{Mode: "Driving"}, 0},
+		{Destination{Mode: "flying"}, 1},
+		{Destination{ArrivalTime: "08:30"}, 1},
+		{Destination{Mode: "transit", ArrivalTime: "half eight"}, 1},
+	}
+	for _, test := range tests {
+		if got := test.destination.validate("d"); len(got) != test.want {
+			t.Errorf("%+v: got %q", test.destination, got)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package flatfinder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Shared HTTP clients, one per upstream so each gets its own timeout and retries
+var (
+	chorusHTTP   = newHTTPClient("chorus", 15*time.Second, 2)
+	lfcHTTP      = newHTTPClient("lfc", 15*time.Second, 2)
+	googleHTTP   = newHTTPClient("google", 15*time.Second, 2)
+	notifierHTTP = newHTTPClient("notifier", 15*time.Second, 3)
+
+	// Token requests are signed by hand, a retry would reuse the nonce
+	tradeMeAuthHTTP = newHTTPClient("trademe", 30*time.Second, 0)
+)
+
+// Backoff limits, Retry-After longer than maxRetryAfter is capped
+var (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+	maxRetryAfter  = 60 * time.Second
+)
+
+// newHTTPClient - Client with a per attempt timeout and retries on 5xx/429, POSTs only on 429
+func newHTTPClient(provider string, timeout time.Duration, maxRetries int) *http.Client {
+	return newHTTPClientWithTransport(provider, timeout, maxRetries, http.DefaultTransport)
+}
+
+// newHTTPClientWithTransport - As newHTTPClient, with next called for each attempt
+func newHTTPClientWithTransport(provider string, timeout time.Duration, maxRetries int, next http.RoundTripper) *http.Client {
+	return &http.Client{
+		Transport: &retryTransport{
+			provider:   provider,
+			timeout:    timeout,
+			maxRetries: maxRetries,
+			next:       next,
+		},
+	}
+}
+
+// retryTransport - Retries failed requests with backoff and jitter, honouring Retry-After
+type retryTransport struct {
+	provider   string
+	timeout    time.Duration
+	maxRetries int
+	next       http.RoundTripper
+}
+
+// RoundTrip - Send the request, retrying until it works or we run out of attempts
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		attemptReq, err := t.attemptRequest(req, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := t.roundTripWithTimeout(attemptReq)
+		if attempt >= t.maxRetries || !shouldRetry(req, resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		wait := retryDelay(attempt, resp)
+		if err != nil {
+			log.Printf("%s request failed, retrying in %s: %s", t.provider, wait.Round(time.Millisecond), err)
+		} else {
+			log.Printf("%s returned %s, retrying in %s", t.provider, resp.Status, wait.Round(time.Millisecond))
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// attemptRequest - Requests with a body need a fresh copy of it to retry
+func (t *retryTransport) attemptRequest(req *http.Request, attempt int) (*http.Request, error) {
+	if attempt == 0 || req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("Cannot retry request without GetBody")
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	attemptReq := req.Clone(req.Context())
+	attemptReq.Body = body
+	return attemptReq, nil
+}
+
+// roundTripWithTimeout - One attempt, the timeout covers reading the body too
+func (t *retryTransport) roundTripWithTimeout(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose - Releases the attempt's context once the body is done with
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// shouldRetry - Network errors, rate limits and server errors are worth another go. A POST
+// may have gone through even though it failed, so it's only retried when it can't have:
+// a 429, or a connection that never opened
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return false
+		}
+		return idempotent(req) || dialFailed(err)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return resp.StatusCode >= 500 && idempotent(req)
+}
+
+// idempotent - Sending the request twice does no more than sending it once
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	default:
+		return false
+	}
+}
+
+// dialFailed - The request never left, as we couldn't connect
+func dialFailed(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// retryDelay - Retry-After if the server sent one, otherwise exponential backoff with jitter
+func retryDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			if wait > maxRetryAfter {
+				wait = maxRetryAfter
+			}
+			return wait
+		}
+	}
+
+	delay := retryBaseDelay << attempt
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+
+	// Half fixed, half random so clients don't retry in lockstep
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// parseRetryAfter - Retry-After is either seconds or an HTTP date
+func parseRetryAfter(retryAfter string) (time.Duration, bool) {
+	if retryAfter == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+
+	return 0, false
+}
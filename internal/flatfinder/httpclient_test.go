@@ -0,0 +1,218 @@
+package flatfinder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeFlaky - Answers with each status in turn, then 200
+type fakeFlaky struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	statuses   []int
+	retryAfter string
+	bodies     []string
+}
+
+func newFakeFlaky(t *testing.T, retryAfter string, statuses ...int) *fakeFlaky {
+	fake := &fakeFlaky{statuses: statuses, retryAfter: retryAfter}
+	fake.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+
+		fake.bodies = append(fake.bodies, string(body))
+		if len(fake.bodies) <= len(fake.statuses) {
+			w.Header().Set("Retry-After", fake.retryAfter)
+			w.WriteHeader(fake.statuses[len(fake.bodies)-1])
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(fake.Close)
+
+	return fake
+}
+
+// requests - Bodies of every request so far
+func (f *fakeFlaky) requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string{}, f.bodies...)
+}
+
+// fastRetries - Shrink the backoff for the test
+func fastRetries(t *testing.T) {
+	realBase, realMax := retryBaseDelay, retryMaxDelay
+	retryBaseDelay, retryMaxDelay = time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { retryBaseDelay, retryMaxDelay = realBase, realMax })
+}
+
+func TestRetryTransport(t *testing.T) {
+	fastRetries(t)
+
+	tests := []struct {
+		name     string
+		method   string
+		statuses []int
+		want     int
+		requests int
+	}{
+		{"recovers from 5xx", "GET", []int{503, 500}, 200, 3},
+		{"recovers from 429", "GET", []int{429}, 200, 2},
+		{"gives up after max retries", "GET", []int{503, 503, 503, 503, 503}, 503, 4},
+		{"4xx isn't retried", "GET", []int{404}, 404, 1},
+		{"POST 5xx isn't retried", "POST", []int{502}, 502, 1},
+		{"POST 429 is retried", "POST", []int{429, 429}, 200, 3},
+	}
+	for _, test := range tests {
+		fake := newFakeFlaky(t, "0", test.statuses...)
+		client := newHTTPClient("test", time.Second, 3)
+
+		req, err := http.NewRequest(test.method, fake.URL, strings.NewReader("alert"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != test.want {
+			t.Errorf("%s: status %d, want %d", test.name, resp.StatusCode, test.want)
+		}
+		requests := fake.requests()
+		if len(requests) != test.requests {
+			t.Errorf("%s: %d requests, want %d", test.name, len(requests), test.requests)
+		}
+
+		// Every attempt gets the whole body
+		for i, body := range requests {
+			if body != "alert" {
+				t.Errorf("%s: request %d body %q", test.name, i, body)
+			}
+		}
+	}
+}
+
+func TestRetryTransportDialError(t *testing.T) {
+	fastRetries(t)
+
+	// Nothing listening, so the POST never left and is safe to send again
+	fake := newFakeFlaky(t, "")
+	fake.Close()
+
+	attempts := 0
+	client := newHTTPClientWithTransport("test", time.Second, 2, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return http.DefaultTransport.RoundTrip(req)
+	}))
+
+	_, err := client.Post(fake.URL, "text/plain", strings.NewReader("alert"))
+	if err == nil {
+		t.Fatal("no error from a closed server")
+	}
+	if attempts != 3 {
+		t.Errorf("%d attempts, want 3", attempts)
+	}
+}
+
+func TestRetryTransportCancelled(t *testing.T) {
+	// Would wait the full minute without the context
+	fake := newFakeFlaky(t, "60", 503, 503)
+	client := newHTTPClient("test", time.Second, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", fake.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now()
+	_, err = client.Do(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error %v, want deadline exceeded", err)
+	}
+	if waited := time.Since(started); waited > 5*time.Second {
+		t.Errorf("waited %s after the context ended", waited)
+	}
+	if requests := len(fake.requests()); requests != 1 {
+		t.Errorf("%d requests, want 1", requests)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	withRetryAfter := func(value string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": []string{value}}}
+	}
+
+	if got := retryDelay(0, withRetryAfter("5")); got != 5*time.Second {
+		t.Errorf("Retry-After seconds: %s, want 5s", got)
+	}
+	if got := retryDelay(0, withRetryAfter("3600")); got != maxRetryAfter {
+		t.Errorf("long Retry-After: %s, want capped at %s", got, maxRetryAfter)
+	}
+
+	date := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	if got := retryDelay(0, withRetryAfter(date)); got < 8*time.Second || got > 10*time.Second {
+		t.Errorf("Retry-After date: %s, want about 10s", got)
+	}
+
+	// No usable Retry-After, backoff doubles with up to half of it random
+	for attempt := 0; attempt < 10; attempt++ {
+		delay := retryBaseDelay << attempt
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+		for i := 0; i < 20; i++ {
+			got := retryDelay(attempt, withRetryAfter("soon"))
+			if got < delay/2 || got > delay {
+				t.Fatalf("attempt %d: delay %s outside %s-%s", attempt, got, delay/2, delay)
+			}
+		}
+	}
+	if got := retryDelay(100, nil); got < retryMaxDelay/2 || got > retryMaxDelay {
+		t.Errorf("huge attempt: delay %s, want capped at %s", got, retryMaxDelay)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"0", 0, true},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", 0, true}, // Past dates mean now
+	}
+	for _, test := range tests {
+		got, ok := parseRetryAfter(test.value)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%q: %s %v, want %s %v", test.value, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+// roundTripFunc - A function as a transport
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
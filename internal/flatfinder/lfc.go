@@ -0,0 +1,209 @@
+package flatfinder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LFCChecker - A local fibre company's address checker that answers in JSON, asked when
+// Chorus says the address is in their area
+type LFCChecker struct {
+	Name string `json:"name"`
+
+	// Matched against the fibre supplier Chorus names, e.g. "Enable". Empty asks for every listing
+	Areas []string `json:"areas,omitempty"`
+
+	// GET URL, {address}, {lat} and {long} are filled in
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Dotted paths into the response, numbers index arrays. Fibre is true for true, Y, YES,
+	// AVAILABLE or a non-zero number
+	FibreField    string `json:"fibre_field"`
+	DownloadField string `json:"download_field,omitempty"`
+	UploadField   string `json:"upload_field,omitempty"`
+}
+
+// validate - Enough to build a request and read the answer
+func (l *LFCChecker) validate(prefix string) []string {
+	problems := []string{}
+
+	if l.Name == "" {
+		problems = append(problems, prefix+": name not set")
+	}
+	if !isHTTPURL(l.requestURL("", 0, 0)) {
+		problems = append(problems, prefix+": url must be an http(s) URL")
+	}
+	if l.FibreField == "" {
+		problems = append(problems, prefix+": fibre_field not set")
+	}
+
+	return problems
+}
+
+// requestURL - Fill the placeholders in
+func (l *LFCChecker) requestURL(address string, lat float64, long float64) string {
+	return strings.NewReplacer(
+		"{address}", url.QueryEscape(address),
+		"{lat}", strconv.FormatFloat(lat, 'f', -1, 64),
+		"{long}", strconv.FormatFloat(long, 'f', -1, 64),
+	).Replace(l.URL)
+}
+
+// lfcProvider - Asks an LFCChecker, caching its answers with Chorus'
+type lfcProvider struct {
+	checker *LFCChecker
+	c       *LocalConfig
+}
+
+func (p *lfcProvider) Name() string {
+	return p.checker.Name
+}
+
+// Covers - Only when there's no fibre yet and it's in one of the checker's areas
+func (p *lfcProvider) Covers(merged BroadbandInfo) bool {
+	if merged.FibreAvailable() {
+		return false
+	}
+	if len(p.checker.Areas) == 0 {
+		return true
+	}
+
+	area := strings.ToLower(merged.lfcArea())
+	for _, name := range p.checker.Areas {
+		if area != "" && strings.Contains(area, strings.ToLower(name)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Lookup - Ask the checker about the listing's address
+func (p *lfcProvider) Lookup(ctx context.Context, listing TradeMeListing) (BroadbandInfo, error) {
+	address := lookupAddress(listing)
+	key := "lfc:" + p.checker.Name + ":" + broadbandAddressKey(address)
+	if info, ok := p.c.cachedBroadbandInfo(key); ok {
+		return info, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.checker.requestURL(address, listing.GeographicLocation.Latitude, listing.GeographicLocation.Longitude), nil)
+	if err != nil {
+		return BroadbandInfo{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, value := range p.checker.Headers {
+		req.Header.Set(name, value)
+	}
+
+	// Do the request
+	resp, err := lfcHTTP.Do(req)
+	if err != nil {
+		return BroadbandInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return BroadbandInfo{}, errors.New("Invalid response from API: " + resp.Status)
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return BroadbandInfo{}, err
+	}
+
+	// Decode JSON
+	var result interface{}
+	err = json.Unmarshal(bodyBytes, &result)
+	if err != nil {
+		return BroadbandInfo{}, err
+	}
+
+	fibre, ok := jsonPath(result, p.checker.FibreField)
+	if !ok {
+		return BroadbandInfo{}, fmt.Errorf("No %s in response", p.checker.FibreField)
+	}
+
+	info := BroadbandInfo{
+		Checked:            true,
+		CheckedAt:          time.Now(),
+		Provider:           p.checker.Name,
+		FibreBuildRequired: !jsonTruthy(fibre),
+		FibreSupplier:      p.checker.Name,
+	}
+	if value, ok := jsonPath(result, p.checker.DownloadField); ok {
+		info.MaxDownMbps = jsonNumber(value)
+	}
+	if value, ok := jsonPath(result, p.checker.UploadField); ok {
+		info.MaxUpMbps = jsonNumber(value)
+	}
+
+	p.c.cacheBroadbandInfo(key, info)
+	return info, nil
+}
+
+// jsonPath - Follow a dotted path through decoded JSON, false if any step is missing
+func jsonPath(value interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return nil, false
+	}
+
+	for _, step := range strings.Split(path, ".") {
+		switch current := value.(type) {
+		case map[string]interface{}:
+			next, ok := current[step]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(step)
+			if err != nil || i < 0 || i >= len(current) {
+				return nil, false
+			}
+			value = current[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// jsonTruthy - Checkers say yes in all sorts of ways
+func jsonTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return chorusYes(v) || strings.EqualFold(v, "available")
+	}
+
+	return false
+}
+
+// jsonNumber - A number, or a string holding one like "1000" or "1000 Mbps"
+func jsonNumber(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case string:
+		fields := strings.Fields(v)
+		if len(fields) > 0 {
+			number, _ := strconv.ParseFloat(fields[0], 64)
+			return number
+		}
+	}
+
+	return 0
+}
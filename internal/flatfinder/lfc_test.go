@@ -0,0 +1,87 @@
+package flatfinder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestLFCBroadband(t *testing.T) {
+	fake := newFakeChorus(t)
+	fake.bccFile = "bcc_enable_area.json"
+
+	asked := 0
+	lfc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked++
+		if r.URL.Query().Get("q") != "12 Cuba Street, Te Aro, Wellington" || r.Header.Get("X-Api-Key") != "key" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"results":[{"fibre":{"available":"Y","speeds":{"down":"1000 Mbps","up":500}}}]}`)
+	}))
+	defer lfc.Close()
+
+	enable := &LFCChecker{
+		Name:          "Enable",
+		Areas:         []string{"enable"},
+		URL:           lfc.URL + "/check?q={address}",
+		Headers:       map[string]string{"X-Api-Key": "key"},
+		FibreField:    "results.0.fibre.available",
+		DownloadField: "results.0.fibre.speeds.down",
+		UploadField:   "results.0.fibre.speeds.up",
+	}
+	northpower := &LFCChecker{Name: "Northpower", Areas: []string{"northpower"}, URL: "http://northpower.invalid/{address}", FibreField: "fibre"}
+
+	c := &LocalConfig{LFCCheckers: []*LFCChecker{northpower, enable}}
+	c.initPipeline()
+
+	listing := TradeMeListing{Address: "12 Cuba Street", Suburb: "Te Aro", Region: "Wellington"}
+
+	// Chorus needs a build, but it's Enable's area and they have fibre there
+	info, _ := c.getBroadband(context.Background(), listing)
+	if !info.FibreAvailable() || info.FibreUnconfirmed || info.Provider != "Enable" {
+		t.Fatalf("got %+v, want fibre from Enable", info)
+	}
+	if !reflect.DeepEqual(info.CheckedBy, []string{"Chorus", "Enable"}) {
+		t.Errorf("checked by %v", info.CheckedBy)
+	}
+	if info.MaxDownMbps != 1000 || info.MaxUpMbps != 500 {
+		t.Errorf("speeds %.0f/%.0f, want 1000/500", info.MaxDownMbps, info.MaxUpMbps)
+	}
+	if summary := info.fibreSummary(); summary != "Yes, Enable (1000/500 Mbps)" {
+		t.Errorf("fibre summary %q", summary)
+	}
+	if asked != 1 {
+		t.Errorf("Enable asked %d times, want 1", asked)
+	}
+
+	// Without a checker for the area Chorus' answer stands, but unconfirmed
+	c.LFCCheckers = nil
+	c.initPipeline()
+	info, _ = c.getBroadband(context.Background(), listing)
+	if info.FibreAvailable() || !info.FibreUnconfirmed {
+		t.Fatalf("got %+v, want unconfirmed", info)
+	}
+	if summary := info.fibreSummary(); summary != "Unconfirmed, Enable Networks area (70/10 Mbps, install 3 days)" {
+		t.Errorf("fibre summary %q", summary)
+	}
+}
+
+func TestMergeBroadband(t *testing.T) {
+	chorus := BroadbandInfo{Checked: true, Provider: "Chorus", FibreBuildRequired: true, FibreSupplier: "Tuatahi First Fibre", FibreUnconfirmed: true, MaxDownMbps: 70}
+	noFibre := BroadbandInfo{Checked: true, Provider: "Tuatahi", FibreBuildRequired: true}
+
+	merged := mergeBroadband(mergeBroadband(BroadbandInfo{}, chorus), noFibre)
+	if merged.FibreAvailable() || merged.FibreUnconfirmed || merged.Provider != "Chorus" || merged.MaxDownMbps != 70 {
+		t.Errorf("confirmed no fibre, got %+v", merged)
+	}
+
+	// A provider that couldn't answer changes nothing
+	merged = mergeBroadband(mergeBroadband(BroadbandInfo{}, chorus), BroadbandInfo{})
+	if !merged.FibreUnconfirmed || len(merged.CheckedBy) != 1 {
+		t.Errorf("failed lookup, got %+v", merged)
+	}
+}
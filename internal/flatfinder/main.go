@@ -1,69 +1,225 @@
 package flatfinder
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"time"
-
-	"github.com/disgoorg/disgo/webhook"
 )
 
 // Our local struct we will store data during runtime
 type LocalConfig struct {
-	DiscordWebhook string         `json:"-"`
-	DiscordTag     string         `json:"-"`
-	DiscordClient  webhook.Client `json:"-"`
+	Notify    NotifierSettings `json:"-"`
+	Notifiers []Notifier       `json:"-"`
+
+	GoogleApiToken string        `json:"-"`
+	Destinations   []Destination `json:"-"`
+
+	TradeMeKey         string `json:"-"`
+	TradeMeSecret      string `json:"-"`
+	TradeMeToken       string `json:"-"`
+	TradeMeTokenSecret string `json:"-"`
+	TradeMeMaxPages    int    `json:"-"`
+	TradeMeAPIURL      string `json:"-"`
+	TradeMeOAuthURL    string `json:"-"`
+
+	// Fetch full listing details for new listings, once each
+	FetchDetails bool `json:"-"`
+	tradeMeHTTP  *http.Client
+
+	// Furthest back to search, and how far to overlap the last poll
+	Since       time.Duration `json:"-"`
+	PollOverlap time.Duration `json:"-"`
+
+	Searches []*Search `json:"-"`
+	Rules    []*Rule   `json:"-"`
+
+	// Searches without their own requirements use these
+	Requirements *Requirements `json:"-"`
 
-	GoogleApiToken  string `json:"-"`
-	GoogleLocation1 string `json:"-"`
-	GoogleLocation2 string `json:"-"`
+	// How often to check sent listings are still up and at the same price, 0 disables
+	WithdrawnCheckEvery time.Duration `json:"-"`
+	NotifyWithdrawn     bool          `json:"-"`
 
-	TradeMeKey    string `json:"-"`
-	TradeMeSecret string `json:"-"`
+	// Score weights, and how often to send each search a digest of its best listings
+	ScoreWeights   ScoreWeights  `json:"-"`
+	TravelScoreMax time.Duration `json:"-"`
+	DigestEvery    time.Duration `json:"-"`
+	DigestSize     int           `json:"-"`
+	DigestMaxAge   time.Duration `json:"-"`
 
-	Suburbs       string `json:"-"`
-	BedroomsMin   string `json:"-"`
-	BedroomsMax   string `json:"-"`
-	PriceMax      string `json:"-"`
-	PropertyTypes string `json:"-"`
+	StateDir string `json:"-"`
+	Store    *Store `json:"-"`
+	CacheDir string `json:"-"`
+	Cache    *Cache `json:"-"`
 
-	PostedProperties map[int64]bool `json:"properties"`
+	// Google results are reused for this long, keyed by lat/long rounded to precision places
+	TravelCacheTTL       time.Duration `json:"-"`
+	TravelCachePrecision int           `json:"-"`
+
+	// Chorus address lookups and results are reused for this long
+	BroadbandCacheTTL time.Duration `json:"-"`
+
+	// Chorus addresses further than this many metres from the listing aren't trusted
+	ChorusMatchDistance float64 `json:"-"`
+
+	// Chorus address API credentials, blank uses the public ones. Refreshed from
+	// ChorusCredentialsURL when Chorus rejects them
+	ChorusClientID       string `json:"-"`
+	ChorusClientSecret   string `json:"-"`
+	ChorusCredentialsURL string `json:"-"`
+	chorusAuth           *chorusAuth
+
+	// Asked after Chorus for addresses in other fibre companies' areas
+	LFCCheckers        []*LFCChecker `json:"-"`
+	broadbandProviders []BroadbandProvider
+
+	// Listings enriched at once, and calls allowed at once per provider
+	EnrichWorkers      int `json:"-"`
+	ChorusConcurrency  int `json:"-"`
+	GoogleConcurrency  int `json:"-"`
+	TradeMeConcurrency int `json:"-"`
+	chorusLimit        limiter
+	googleLimit        limiter
+	trademeLimit       limiter
 }
 
 var Conf LocalConfig
 
-// Launch!
-func Launch() {
-	// Load discord
-	Conf.initDiscord()
+// ShutdownTimeout - How long in-flight work gets to finish after a signal
+var ShutdownTimeout = 20 * time.Second
 
-	// Load previously posted properties
-	Conf.loadConfig()
+// Launch! Runs until ctx is cancelled, then shuts down cleanly
+func Launch(ctx context.Context) {
+	// Load notifiers
+	err := Conf.initNotifiers()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// Intial run
-	Conf.pollUpdates()
+	// Load searches, each may have its own notifiers
+	err = Conf.initSearches()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Polling searches: %s", Conf.searchNames())
 
-	// Run every minute!
-	ticker := time.NewTicker(1 * time.Minute)
-	quit := make(chan struct{})
-	for {
-		select {
-		case <-ticker.C:
-			Conf.pollUpdates()
-		case <-quit:
-			ticker.Stop()
-			return
+	Conf.initPipeline()
+
+	// Built up front so polling goroutines share one signed client
+	Conf.trademeClient()
+
+	// Load previously seen listings
+	Conf.Store, err = OpenStoreSafely(Conf.storeFilePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = Conf.Store.Backup()
+	if err != nil {
+		log.Printf("Failed to back up listing database: %s", err)
+	}
+	err = Conf.Store.migrateLegacyState(Conf.legacyStateFilePath(), Conf.Searches)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Loaded %d previously seen listings", Conf.Store.CountListings())
+
+	// Only saves calls, so carry on without it
+	Conf.Cache, err = OpenCache(Conf.cacheFilePath())
+	if err != nil {
+		log.Printf("Not caching lookups: %s", err)
+	}
+
+	if Conf.Cache != nil && Conf.TravelCacheTTL > 0 {
+		pruned, err := Conf.Cache.PruneTravelTimes(Conf.TravelCacheTTL)
+		if err != nil {
+			log.Printf("Failed to prune travel time cache: %s", err)
+		} else if pruned > 0 {
+			log.Printf("Pruned %d expired travel times", pruned)
 		}
 	}
-}
+	if Conf.Cache != nil && Conf.BroadbandCacheTTL > 0 {
+		pruned, err := Conf.Cache.PruneBroadband(Conf.BroadbandCacheTTL)
+		if err != nil {
+			log.Printf("Failed to prune broadband cache: %s", err)
+		} else if pruned > 0 {
+			log.Printf("Pruned %d expired broadband results", pruned)
+		}
+	}
+
+	// Work carries on after a signal until ShutdownTimeout, then is cancelled
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+
+	polling := make(chan struct{})
+	go func() {
+		defer close(polling)
+
+		// Intial run
+		Conf.pollUpdates(workCtx, ctx.Done())
+
+		// Run every minute!
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				Conf.pollUpdates(workCtx, ctx.Done())
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Shutting down, waiting up to %s for in-flight work", ShutdownTimeout)
+
+	select {
+	case <-polling:
+	case <-time.After(ShutdownTimeout):
+		log.Print("In-flight work didn't finish in time, cancelling")
+		cancelWork()
+		<-polling
+	}
 
-// pollUpdates - check for new listings!
-func (c *LocalConfig) pollUpdates() {
-	err := Conf.searchTrademe()
+	// Flush state, keeping a backup of where we got to
+	err = Conf.Store.Backup()
 	if err != nil {
-		log.Println(err)
-		return
+		log.Printf("Failed to back up listing database: %s", err)
 	}
+	err = Conf.Store.Close()
+	if err != nil {
+		log.Print(err)
+	}
+	if Conf.Cache != nil {
+		err = Conf.Cache.Close()
+		if err != nil {
+			log.Print(err)
+		}
+	}
+	log.Print("Shutdown complete")
+}
 
-	// Update config
-	c.storeConfig()
+// pollUpdates - check for new listings! Stops starting searches once shutdown begins
+func (c *LocalConfig) pollUpdates(ctx context.Context, shutdown <-chan struct{}) {
+	for _, search := range c.Searches {
+		select {
+		case <-shutdown:
+			return
+		default:
+		}
+
+		err := c.searchTrademe(ctx, search)
+		if err != nil {
+			log.Printf("[%s] %s", search.Name, err)
+		}
+	}
+
+	select {
+	case <-shutdown:
+	default:
+		c.retryFailedAlerts(ctx)
+		c.checkListings(ctx)
+		c.sendDigests(ctx)
+	}
 }
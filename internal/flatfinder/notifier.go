@@ -0,0 +1,268 @@
+package flatfinder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Notifier - Something that can tell someone about a new listing
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, listing EnrichedListing) error
+}
+
+// EnrichedListing - A Trade Me listing with everything we looked up about it
+type EnrichedListing struct {
+	TradeMeListing
+
+	Broadband   BroadbandInfo `json:"Broadband"`
+	TravelTimes []TravelTime  `json:"TravelTimes"`
+
+	// 0 to 100 from the scoring weights
+	Score float64 `json:"Score"`
+
+	// Why it went to the low priority notifiers, blank for a normal alert
+	LowPriority string `json:"LowPriority,omitempty"`
+
+	// Body, photos, attributes and open homes, only with fetch_details on
+	Details *TradeMeListingDetails `json:"Details,omitempty"`
+
+	// What changed, previous values are only set for price drops and relists
+	Alert                AlertKind `json:"Alert"`
+	PreviousListingID    int64     `json:"PreviousListingId,omitempty"`
+	PreviousRentPerWeek  int       `json:"PreviousRentPerWeek,omitempty"`
+	PreviousPriceDisplay string    `json:"PreviousPriceDisplay,omitempty"`
+}
+
+// TravelTime - Distance/time from a listing to somewhere we care about
+type TravelTime struct {
+	Destination string `json:"Destination"`
+	Mode        string `json:"Mode,omitempty"`
+	Summary     string `json:"Summary"`
+
+	// Zero if Google couldn't work it out
+	Duration time.Duration `json:"Duration,omitempty"`
+}
+
+// NotifierSettings - Which notifiers to enable and where they send to
+type NotifierSettings struct {
+	Types          string `json:"notifiers,omitempty"`
+	DiscordWebhook string `json:"discord_webhook,omitempty"`
+	DiscordTag     string `json:"discord_tag,omitempty"`
+	WebhookURL     string `json:"webhook_url,omitempty"`
+}
+
+// inferTypes - A webhook set without a notifiers list means send to it
+func (s NotifierSettings) inferTypes() NotifierSettings {
+	if s.Types == "" && s.DiscordWebhook != "" {
+		s.Types = "discord"
+	}
+	if s.Types == "" && s.WebhookURL != "" {
+		s.Types = "webhook"
+	}
+
+	return s
+}
+
+// initNotifiers - Build every notifier enabled in config
+func (c *LocalConfig) initNotifiers() error {
+	notifiers, err := c.Notify.buildNotifiers()
+	if err != nil {
+		return err
+	}
+
+	c.Notifiers = notifiers
+	if len(c.Notifiers) == 0 {
+		log.Print("No notifiers enabled. New listings will only be recorded")
+	}
+
+	return nil
+}
+
+// validate - Check each notifier type is known and has somewhere to send to
+func (s NotifierSettings) validate(prefix string) []string {
+	problems := []string{}
+
+	for _, name := range strings.Split(s.Types, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "", "log":
+		case "discord":
+			if s.DiscordWebhook == "" {
+				problems = append(problems, prefix+": discord_webhook (DISCORD_WEBHOOK) not set")
+			}
+		case "webhook":
+			if s.WebhookURL == "" {
+				problems = append(problems, prefix+": webhook_url (WEBHOOK_URL) not set")
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("%s: unknown notifier %s", prefix, name))
+		}
+	}
+
+	return problems
+}
+
+// buildNotifiers - Create a notifier for each comma separated type
+func (s NotifierSettings) buildNotifiers() ([]Notifier, error) {
+	notifiers := []Notifier{}
+
+	for _, name := range strings.Split(s.Types, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		notifier, err := s.newNotifier(name)
+		if err != nil {
+			return nil, err
+		}
+
+		notifiers = append(notifiers, notifier)
+		log.Printf("Notifier enabled: %s", notifier.Name())
+	}
+
+	return notifiers, nil
+}
+
+// newNotifier - Create a notifier by type name
+func (s NotifierSettings) newNotifier(name string) (Notifier, error) {
+	switch name {
+	case "discord":
+		return newDiscordNotifier(s.DiscordWebhook, s.DiscordTag)
+	case "webhook":
+		return newWebhookNotifier(s.WebhookURL)
+	case "log":
+		return &LogNotifier{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown notifier: %s", name)
+	}
+}
+
+// notify - Send a listing to every notifier for the search, returns those that failed
+func (c *LocalConfig) notify(ctx context.Context, search *Search, listing EnrichedListing) []string {
+	return c.notifyWith(ctx, search, c.alertNotifiers(search, listing.LowPriority), listing)
+}
+
+// alertNotifiers - The search's notifiers, or the low priority ones if a requirement was missed
+func (c *LocalConfig) alertNotifiers(search *Search, lowPriority string) []Notifier {
+	if lowPriority != "" {
+		return c.searchRequirements(search).lowPriority
+	}
+
+	return search.Notifiers
+}
+
+// notifyWith - Send a listing to the given notifiers, returns those that failed
+func (c *LocalConfig) notifyWith(ctx context.Context, search *Search, notifiers []Notifier, listing EnrichedListing) []string {
+	failed := []string{}
+	for _, notifier := range notifiers {
+		err := notifier.Notify(ctx, listing)
+		if err != nil {
+			log.Printf("[%s] %s notifier failed: %s", search.Name, notifier.Name(), err)
+			failed = append(failed, notifier.Name())
+		}
+	}
+
+	return failed
+}
+
+// LogNotifier - Writes listings to the log, handy when running by hand
+type LogNotifier struct{}
+
+func (n *LogNotifier) Name() string {
+	return "log"
+}
+
+// Notify - Log a one line summary of the listing
+func (n *LogNotifier) Notify(ctx context.Context, listing EnrichedListing) error {
+	price := listing.PriceDisplay
+	if change := priceChange(listing); change != "" {
+		price = change
+	}
+
+	log.Printf(
+		"%s | %s | %s | %d bedrooms | score %.0f | https://trademe.co.nz/%d",
+		alertTitle(listing),
+		listing.Address,
+		price,
+		listing.Bedrooms,
+		listing.Score,
+		listing.ListingID,
+	)
+
+	return nil
+}
+
+// NotifyDigest - Log the ranking, one line per listing
+func (n *LogNotifier) NotifyDigest(ctx context.Context, search string, listings []EnrichedListing) error {
+	log.Printf("Top %d listings for %s:", len(listings), search)
+	for i, listing := range listings {
+		log.Printf("%d. %.0f | %s | %s | https://trademe.co.nz/%d", i+1, listing.Score, listing.Title, listing.PriceDisplay, listing.ListingID)
+	}
+
+	return nil
+}
+
+// WebhookNotifier - POSTs the enriched listing as JSON to any URL
+type WebhookNotifier struct {
+	URL string
+}
+
+// newWebhookNotifier - Validate and create a generic webhook notifier
+func newWebhookNotifier(webhookURL string) (*WebhookNotifier, error) {
+	if webhookURL == "" {
+		return nil, errors.New("WEBHOOK_URL not set")
+	}
+
+	return &WebhookNotifier{URL: webhookURL}, nil
+}
+
+func (n *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+// Notify - POST the listing JSON to the webhook
+func (n *WebhookNotifier) Notify(ctx context.Context, listing EnrichedListing) error {
+	return n.post(ctx, listing)
+}
+
+// NotifyDigest - POST the ranked listings, best first
+func (n *WebhookNotifier) NotifyDigest(ctx context.Context, search string, listings []EnrichedListing) error {
+	return n.post(ctx, struct {
+		Digest   string            `json:"Digest"`
+		Listings []EnrichedListing `json:"Listings"`
+	}{search, listings})
+}
+
+// post - Send anything as JSON to the webhook
+func (n *WebhookNotifier) post(ctx context.Context, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := notifierHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("Invalid response from webhook: " + resp.Status)
+	}
+
+	return nil
+}
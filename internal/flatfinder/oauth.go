@@ -0,0 +1,300 @@
+package flatfinder
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TradeMeOAuthScope - Permissions asked for when authorizing as a member
+var TradeMeOAuthScope = "MyTradeMeRead"
+
+// OAuthCredentials - Consumer key/secret, plus a member token once authorized
+type OAuthCredentials struct {
+	ConsumerKey    string `json:"-"`
+	ConsumerSecret string `json:"-"`
+	Token          string `json:"token"`
+	TokenSecret    string `json:"token_secret"`
+}
+
+// signRequest - Add an OAuth 1.0a HMAC-SHA1 Authorization header
+func (o OAuthCredentials) signRequest(req *http.Request, extra map[string]string) error {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+
+	oauthParams := map[string]string{
+		"oauth_consumer_key":     o.ConsumerKey,
+		"oauth_nonce":            hex.EncodeToString(nonce),
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_version":          "1.0",
+	}
+	if o.Token != "" {
+		oauthParams["oauth_token"] = o.Token
+	}
+	for key, value := range extra {
+		oauthParams[key] = value
+	}
+
+	baseString, err := oauthBaseString(req, oauthParams)
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha1.New, []byte(oauthEscape(o.ConsumerSecret)+"&"+oauthEscape(o.TokenSecret)))
+	mac.Write([]byte(baseString))
+	oauthParams["oauth_signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	header := []string{}
+	for _, key := range sortedKeys(oauthParams) {
+		header = append(header, fmt.Sprintf("%s=\"%s\"", oauthEscape(key), oauthEscape(oauthParams[key])))
+	}
+	req.Header.Set("Authorization", "OAuth "+strings.Join(header, ", "))
+
+	return nil
+}
+
+// oauthBaseString - METHOD&url&params as per RFC 5849 3.4.1
+func oauthBaseString(req *http.Request, oauthParams map[string]string) (string, error) {
+	params := [][2]string{}
+	add := func(key string, value string) {
+		params = append(params, [2]string{oauthEscape(key), oauthEscape(value)})
+	}
+
+	for key, value := range oauthParams {
+		add(key, value)
+	}
+	for key, values := range req.URL.Query() {
+		for _, value := range values {
+			add(key, value)
+		}
+	}
+
+	// Form bodies are signed too
+	if req.Body != nil && req.GetBody != nil && strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return "", err
+		}
+		form, err := url.ParseQuery(string(data))
+		if err != nil {
+			return "", err
+		}
+		for key, values := range form {
+			for _, value := range values {
+				add(key, value)
+			}
+		}
+	}
+	// By name then value, sorting "name=value" would put "a2" before "a"
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	normalized := []string{}
+	for _, param := range params {
+		normalized = append(normalized, param[0]+"="+param[1])
+	}
+
+	baseURL := *req.URL
+	baseURL.RawQuery = ""
+	baseURL.Fragment = ""
+	baseURL.Scheme = strings.ToLower(baseURL.Scheme)
+	baseURL.Host = strings.ToLower(baseURL.Host)
+
+	return strings.ToUpper(req.Method) + "&" + oauthEscape(baseURL.String()) + "&" + oauthEscape(strings.Join(normalized, "&")), nil
+}
+
+// oauthEscape - RFC 3986 percent encoding, only unreserved characters left alone
+func oauthEscape(s string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(s) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '.' || b == '_' || b == '~' {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+
+	return escaped.String()
+}
+
+// sortedKeys - Map keys in order, for a stable header
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// oauthTransport - Signs every attempt, so retries get a fresh nonce and timestamp
+type oauthTransport struct {
+	credentials OAuthCredentials
+	next        http.RoundTripper
+}
+
+func (t *oauthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	signed := req.Clone(req.Context())
+	err := t.credentials.signRequest(signed, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.next.RoundTrip(signed)
+}
+
+// oauthCredentials - Credentials for signing Trade Me requests
+func (c *LocalConfig) oauthCredentials() OAuthCredentials {
+	return OAuthCredentials{
+		ConsumerKey:    c.TradeMeKey,
+		ConsumerSecret: c.TradeMeSecret,
+		Token:          c.TradeMeToken,
+		TokenSecret:    c.TradeMeTokenSecret,
+	}
+}
+
+// tokenFilePath - Where the member token from --trademe-auth is kept
+func (c *LocalConfig) tokenFilePath() string {
+	return filepath.Join(c.StateDir, "trademe_token.json")
+}
+
+// loadStoredToken - Use the saved member token unless config set one
+func (c *LocalConfig) loadStoredToken() error {
+	if c.TradeMeToken != "" || !fileExists(c.tokenFilePath()) {
+		return nil
+	}
+
+	data, err := os.ReadFile(c.tokenFilePath())
+	if err != nil {
+		return err
+	}
+
+	var token OAuthCredentials
+	err = json.Unmarshal(data, &token)
+	if err != nil {
+		return fmt.Errorf("Invalid %s: %s", c.tokenFilePath(), err)
+	}
+
+	c.TradeMeToken = token.Token
+	c.TradeMeTokenSecret = token.TokenSecret
+	return nil
+}
+
+// TrademeAuthorize - Run the request token, authorize, access token dance and save the token
+func (c *LocalConfig) TrademeAuthorize(ctx context.Context, in io.Reader, out io.Writer) error {
+	credentials := c.oauthCredentials()
+	credentials.Token = ""
+	credentials.TokenSecret = ""
+
+	requestURL := c.TradeMeOAuthURL + "/RequestToken?scope=" + url.QueryEscape(TradeMeOAuthScope)
+	requestToken, err := c.oauthTokenRequest(ctx, credentials, requestURL, map[string]string{"oauth_callback": "oob"})
+	if err != nil {
+		return fmt.Errorf("Request token failed: %s", err)
+	}
+
+	fmt.Fprintf(out, "Open this URL, log in and approve access:\n\n  %s/Authorize?oauth_token=%s\n\n", c.TradeMeOAuthURL, url.QueryEscape(requestToken.Token))
+	fmt.Fprint(out, "Then enter the verification code: ")
+
+	verifier, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && verifier == "" {
+		return err
+	}
+	verifier = strings.TrimSpace(verifier)
+	if verifier == "" {
+		return errors.New("No verification code entered")
+	}
+
+	credentials.Token = requestToken.Token
+	credentials.TokenSecret = requestToken.TokenSecret
+	accessToken, err := c.oauthTokenRequest(ctx, credentials, c.TradeMeOAuthURL+"/AccessToken", map[string]string{"oauth_verifier": verifier})
+	if err != nil {
+		return fmt.Errorf("Access token failed: %s", err)
+	}
+
+	data, err := json.Marshal(accessToken)
+	if err != nil {
+		return err
+	}
+
+	// Secret, so only readable by us
+	err = writeFileAtomic(c.tokenFilePath(), 0600, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Saved Trade Me member token to %s\n", c.tokenFilePath())
+	return nil
+}
+
+// oauthTokenRequest - POST a signed token request and parse the token out of the response
+func (c *LocalConfig) oauthTokenRequest(ctx context.Context, credentials OAuthCredentials, requestURL string, extra map[string]string) (OAuthCredentials, error) {
+	var token OAuthCredentials
+
+	req, err := http.NewRequestWithContext(ctx, "POST", requestURL, nil)
+	if err != nil {
+		return token, err
+	}
+
+	err = credentials.signRequest(req, extra)
+	if err != nil {
+		return token, err
+	}
+
+	resp, err := tradeMeAuthHTTP.Do(req)
+	if err != nil {
+		return token, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return token, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return token, fmt.Errorf("Invalid response from API: %s %s", resp.Status, strings.TrimSpace(string(bodyBytes)))
+	}
+
+	values, err := url.ParseQuery(string(bodyBytes))
+	if err != nil {
+		return token, err
+	}
+
+	token.Token = values.Get("oauth_token")
+	token.TokenSecret = values.Get("oauth_token_secret")
+	if token.Token == "" || token.TokenSecret == "" {
+		return token, errors.New("No token in response")
+	}
+
+	return token, nil
+}
@@ -0,0 +1,164 @@
+package flatfinder
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// oauthHeaderParam - A parameter from an OAuth Authorization header, unescaped
+func oauthHeaderParam(header string, name string) string {
+	match := regexp.MustCompile(name + `="([^"]*)"`).FindStringSubmatch(header)
+	if match == nil {
+		return ""
+	}
+	value, err := url.PathUnescape(match[1])
+	if err != nil {
+		return match[1]
+	}
+
+	return value
+}
+
+func TestOAuthBaseString(t *testing.T) {
+	// RFC 5849 3.4.1.1, query and form body parameters both signed
+	req, err := http.NewRequest("POST", "http://EXAMPLE.COM/request?b5=%3D%253D&a3=a&c%40=&a2=r%20b", strings.NewReader("c2&a3=2+q"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	got, err := oauthBaseString(req, map[string]string{
+		"oauth_consumer_key":     "9djdj82h48djs9d2",
+		"oauth_token":            "kkk9d7dh3k39sjv7",
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        "137131201",
+		"oauth_nonce":            "7d8f3e4a",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "POST&http%3A%2F%2Fexample.com%2Frequest&a2%3Dr%2520b%26a3%3D2%2520q%26a3%3Da%26b5%3D%253D%25253D%26c%2540%3D%26c2%3D%26oauth_consumer_key%3D9djdj82h48djs9d2%26oauth_nonce%3D7d8f3e4a%26oauth_signature_method%3DHMAC-SHA1%26oauth_timestamp%3D137131201%26oauth_token%3Dkkk9d7dh3k39sjv7"
+	if got != want {
+		t.Errorf("base string\n got %s\nwant %s", got, want)
+	}
+
+	// Sorted by name, then value
+	req, _ = http.NewRequest("GET", "https://api.trademe.co.nz/v1/x.json?a2=1&a=2&a=1", nil)
+	got, _ = oauthBaseString(req, map[string]string{})
+	if want := "GET&https%3A%2F%2Fapi.trademe.co.nz%2Fv1%2Fx.json&a%3D1%26a%3D2%26a2%3D1"; got != want {
+		t.Errorf("base string\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestOAuthSignRequest(t *testing.T) {
+	// Twitter's documented "Creating a signature" example
+	req, err := http.NewRequest(
+		"POST",
+		"https://api.twitter.com/1.1/statuses/update.json?include_entities=true",
+		strings.NewReader("status=Hello%20Ladies%20%2B%20Gentlemen%2C%20a%20signed%20OAuth%20request%21"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	credentials := OAuthCredentials{
+		ConsumerKey:    "xvz1evFS4wEEPTGEFPHBog",
+		ConsumerSecret: "kAcSOqF21Fu85e7zjz7ZN2U4ZRhfV3WpwPAoE3Z7kBw",
+		Token:          "370773112-GmHxMAgYyLbNEtIKZeRNFsMKPR9EyMZeS9weJAEb",
+		TokenSecret:    "LswwdoUaIvS8ltyTt5jkRh4J50vUPVVHtR2YPi5kE",
+	}
+	err = credentials.signRequest(req, map[string]string{
+		"oauth_nonce":     "kYjzVBB8Y0ZFabxSWbWovY3uYSQ2pTgmZeNu2VS4cg",
+		"oauth_timestamp": "1318622958",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := req.Header.Get("Authorization")
+	if got := oauthHeaderParam(header, "oauth_signature"); got != "hCtSmYh+iHYCEqBWrE7C7hYmtUk=" {
+		t.Errorf("signature %s, want hCtSmYh+iHYCEqBWrE7C7hYmtUk=", got)
+	}
+	if !strings.HasPrefix(header, "OAuth ") || oauthHeaderParam(header, "oauth_token") != credentials.Token {
+		t.Errorf("header %s", header)
+	}
+}
+
+func TestTrademeAuthorize(t *testing.T) {
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		requests = append(requests, r.URL.Path)
+
+		switch {
+		case r.Method != "POST" || oauthHeaderParam(auth, "oauth_consumer_key") != "key" || oauthHeaderParam(auth, "oauth_signature") == "":
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		case r.URL.Path == "/Oauth/RequestToken":
+			if r.URL.Query().Get("scope") != TradeMeOAuthScope || oauthHeaderParam(auth, "oauth_callback") != "oob" || oauthHeaderParam(auth, "oauth_token") != "" {
+				http.Error(w, "Bad request token request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte("oauth_token=request-token&oauth_token_secret=request-secret&oauth_callback_confirmed=true"))
+		case r.URL.Path == "/Oauth/AccessToken":
+			if oauthHeaderParam(auth, "oauth_token") != "request-token" || oauthHeaderParam(auth, "oauth_verifier") != "123456" {
+				http.Error(w, "Bad access token request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte("oauth_token=member-token&oauth_token_secret=member-secret"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	c := &LocalConfig{
+		TradeMeKey:      "key",
+		TradeMeSecret:   "secret",
+		TradeMeOAuthURL: server.URL + "/Oauth",
+		StateDir:        t.TempDir(),
+	}
+
+	out := &bytes.Buffer{}
+	err := c.TrademeAuthorize(context.Background(), strings.NewReader("123456\n"), out)
+	if err != nil {
+		t.Fatalf("%s\n%s", err, out)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("requests %q, want request then access token", requests)
+	}
+	if !strings.Contains(out.String(), "/Oauth/Authorize?oauth_token=request-token") {
+		t.Errorf("no authorize URL in %q", out)
+	}
+
+	info, err := os.Stat(c.tokenFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("token file mode %s, want 0600", info.Mode().Perm())
+	}
+
+	err = c.loadStoredToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TradeMeToken != "member-token" || c.TradeMeTokenSecret != "member-secret" {
+		t.Errorf("loaded token %s/%s", c.TradeMeToken, c.TradeMeTokenSecret)
+	}
+
+	// No code entered
+	err = (&LocalConfig{TradeMeKey: "key", TradeMeSecret: "secret", TradeMeOAuthURL: server.URL + "/Oauth", StateDir: t.TempDir()}).
+		TrademeAuthorize(context.Background(), strings.NewReader("\n"), &bytes.Buffer{})
+	if err == nil {
+		t.Error("authorized without a verification code")
+	}
+}
@@ -0,0 +1,179 @@
+package flatfinder
+
+import (
+	"context"
+	"log"
+)
+
+// listingJob - A listing making its way from dedupe through enrich to notify
+type listingJob struct {
+	stored *StoredListing
+	enrich bool
+	done   chan struct{}
+
+	// Rule that rejected the listing, and requirement it missed, set by the worker
+	rejected string
+	missed   string
+
+	// A lookup failed, so a missed requirement might not really be missed
+	lookupErr error
+
+	// Alert and Previous fields, the rest comes from stored once enriched
+	alert EnrichedListing
+}
+
+// newListingJob - Queue a listing to be sent
+func newListingJob(stored *StoredListing, alert EnrichedListing, enrich bool) *listingJob {
+	return &listingJob{
+		stored: stored,
+		enrich: enrich,
+		done:   make(chan struct{}),
+		alert:  alert,
+	}
+}
+
+// enriched - Stored results with the alert details on top
+func (j *listingJob) enriched() EnrichedListing {
+	enriched := j.stored.enriched()
+	enriched.Alert = j.alert.Alert
+	enriched.PreviousListingID = j.alert.PreviousListingID
+	enriched.PreviousRentPerWeek = j.alert.PreviousRentPerWeek
+	enriched.PreviousPriceDisplay = j.alert.PreviousPriceDisplay
+
+	return enriched
+}
+
+// limiter - Caps how many calls to a provider run at once
+type limiter chan struct{}
+
+// newLimiter - Allow up to n calls at once
+func newLimiter(n int) limiter {
+	if n < 1 {
+		n = 1
+	}
+
+	return make(limiter, n)
+}
+
+// run - Wait for a free slot then call f
+func (l limiter) run(f func()) {
+	if l == nil {
+		f()
+		return
+	}
+
+	l <- struct{}{}
+	defer func() { <-l }()
+	f()
+}
+
+// initPipeline - Set up the per-provider limits, Chorus' credentials and the broadband providers
+func (c *LocalConfig) initPipeline() {
+	c.chorusLimit = newLimiter(c.ChorusConcurrency)
+	c.googleLimit = newLimiter(c.GoogleConcurrency)
+	c.trademeLimit = newLimiter(c.TradeMeConcurrency)
+	c.chorusAuth = newChorusAuth(c.ChorusClientID, c.ChorusClientSecret, c.ChorusCredentialsURL)
+
+	// Chorus first, it says whose area the address is in
+	c.broadbandProviders = []BroadbandProvider{&chorusProvider{c: c}}
+	for _, checker := range c.LFCCheckers {
+		c.broadbandProviders = append(c.broadbandProviders, &lfcProvider{checker: checker, c: c})
+	}
+}
+
+// handleTrademeListings - Dedupe, enrich concurrently, then notify in search order. Returns how
+// many were held back because a lookup failed, they're only tried again if search finds them again
+func (c *LocalConfig) handleTrademeListings(ctx context.Context, search *Search, listings []TradeMeListing) int {
+	// Dedupe runs in order as it reads and writes the store
+	jobs := []*listingJob{}
+	seen := map[int64]bool{}
+	for _, listing := range listings {
+		// Pages can shift while we walk them, so skip repeats
+		if seen[listing.ListingID] {
+			continue
+		}
+		seen[listing.ListingID] = true
+
+		job := c.parseTrademeListing(search, listing)
+		if job != nil {
+			jobs = append(jobs, job)
+		}
+	}
+	if len(jobs) == 0 {
+		return 0
+	}
+
+	rules := c.searchRules(search)
+	requirements := c.searchRequirements(search)
+	workers := c.EnrichWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Enrich, workers only touch their own job
+	queue := make(chan *listingJob)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range queue {
+				if ctx.Err() == nil {
+					c.fetchDetails(ctx, job.stored)
+				}
+
+				// No point looking up listings the rules turn down
+				job.rejected = checkRules(rules, job.enriched())
+				if job.rejected == "" && job.enrich && ctx.Err() == nil {
+					enriched, err := c.enrichListing(ctx, job.stored.Listing)
+					job.stored.setEnrichment(enriched, err)
+					job.lookupErr = err
+				}
+				if job.rejected == "" {
+					job.missed = requirements.check(job.enriched())
+				}
+				close(job.done)
+			}
+		}()
+	}
+
+	go func() {
+		for _, job := range jobs {
+			queue <- job
+		}
+		close(queue)
+	}()
+
+	// Notify in search order as soon as each one is ready
+	held := 0
+	for _, job := range jobs {
+		<-job.done
+
+		// Cancelled, leave the rest unsent so the next run picks them up
+		if ctx.Err() != nil {
+			continue
+		}
+		if job.rejected != "" {
+			c.rejectListing(search, job.stored, job.rejected)
+			continue
+		}
+
+		// Can't tell if it really missed, don't remember it as rejected or send it anywhere yet
+		if job.missed != "" && job.lookupErr != nil {
+			log.Printf("[%s] Holding %d %s until lookups work again: %s", search.Name, job.stored.Listing.ListingID, job.stored.Listing.Title, job.missed)
+			held++
+			continue
+		}
+
+		// Missed a requirement, drop it unless there's somewhere low priority to send it
+		enriched := job.enriched()
+		if job.missed != "" {
+			if len(requirements.lowPriority) == 0 {
+				c.rejectListing(search, job.stored, "requirement: "+job.missed)
+				continue
+			}
+			enriched.LowPriority = job.missed
+		}
+		enriched.Score = c.scoreListing(search, enriched)
+		c.sendAlert(ctx, search, job.stored, enriched)
+	}
+
+	return held
+}
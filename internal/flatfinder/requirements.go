@@ -0,0 +1,154 @@
+package flatfinder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Requirements - Hard limits on broadband and travel, checked once a listing is enriched
+type Requirements struct {
+	Fibre        bool    `json:"fibre,omitempty"`
+	MinSpeedMbps float64 `json:"min_speed_mbps,omitempty"`
+
+	// Destination name -> Go duration like "30m"
+	MaxTravel map[string]string `json:"max_travel,omitempty"`
+
+	// Fail listings we couldn't check, rather than letting them through
+	RejectUnknown bool `json:"reject_unknown,omitempty"`
+
+	// Send listings that fail here instead of dropping them
+	LowPriority NotifierSettings `json:"low_priority,omitempty"`
+
+	maxTravel   map[string]time.Duration
+	lowPriority []Notifier
+}
+
+// validate - Durations parse and every destination exists
+func (r *Requirements) validate(prefix string, destinations []Destination) []string {
+	problems := []string{}
+
+	if r.MinSpeedMbps < 0 {
+		problems = append(problems, prefix+": min_speed_mbps must be 0 or more")
+	}
+
+	known := map[string]bool{}
+	for _, destination := range destinations {
+		known[destination.Name] = true
+	}
+	for _, name := range sortedKeys(r.MaxTravel) {
+		if !known[name] {
+			problems = append(problems, fmt.Sprintf("%s: max_travel: unknown destination %s", prefix, name))
+		}
+		max, err := time.ParseDuration(r.MaxTravel[name])
+		if err != nil || max <= 0 {
+			problems = append(problems, fmt.Sprintf("%s: max_travel: %s must be a duration like 30m", prefix, name))
+		}
+	}
+
+	return append(problems, r.LowPriority.inferTypes().validate(prefix+".low_priority")...)
+}
+
+// init - Parse travel limits and build the low priority notifiers
+func (r *Requirements) init() error {
+	r.maxTravel = map[string]time.Duration{}
+	for name, max := range r.MaxTravel {
+		duration, err := time.ParseDuration(max)
+		if err != nil {
+			return fmt.Errorf("max_travel: %s: %s", name, err)
+		}
+		r.maxTravel[name] = duration
+	}
+
+	// Same as a search's settings, though with no fallback to the main channel
+	notifiers, err := r.LowPriority.inferTypes().buildNotifiers()
+	if err != nil {
+		return err
+	}
+	r.lowPriority = notifiers
+
+	return nil
+}
+
+// check - "" if the listing meets every requirement, otherwise the first it missed
+func (r *Requirements) check(listing EnrichedListing) string {
+	if r == nil {
+		return ""
+	}
+
+	if r.Fibre || r.MinSpeedMbps > 0 {
+		switch {
+		case listing.Broadband.AddressUncertain:
+			if r.RejectUnknown {
+				return "broadband: address uncertain"
+			}
+		case !listing.Broadband.Checked:
+			if r.RejectUnknown {
+				return "broadband unknown"
+			}
+		case listing.Broadband.FibreUnconfirmed && (r.Fibre || listing.Broadband.MaxDownMbps < r.MinSpeedMbps):
+			if r.RejectUnknown {
+				return fmt.Sprintf("fibre unconfirmed in %s area", listing.Broadband.lfcArea())
+			}
+		case r.Fibre && !listing.Broadband.FibreAvailable():
+			return "no fibre"
+		case listing.Broadband.MaxDownMbps < r.MinSpeedMbps:
+			return fmt.Sprintf("max speed %.0f Mbps, need %.0f Mbps", listing.Broadband.MaxDownMbps, r.MinSpeedMbps)
+		}
+	}
+
+	for _, name := range sortedKeys(r.MaxTravel) {
+		var duration time.Duration
+		for _, travelTime := range listing.TravelTimes {
+			if travelTime.Destination == name {
+				duration = travelTime.Duration
+			}
+		}
+
+		if duration == 0 {
+			if r.RejectUnknown {
+				return fmt.Sprintf("travel time to %s unknown", name)
+			}
+			continue
+		}
+		if duration > r.maxTravel[name] {
+			return fmt.Sprintf("%s to %s, max %s", duration.Round(time.Minute), name, r.maxTravel[name])
+		}
+	}
+
+	return ""
+}
+
+// searchRequirements - The search's own, or the global ones
+func (c *LocalConfig) searchRequirements(search *Search) *Requirements {
+	if search.Requirements != nil {
+		return search.Requirements
+	}
+
+	return c.Requirements
+}
+
+// filterFingerprint - Hash that changes whenever the rules or requirements for a search do.
+// Notifier settings are left out so webhooks never end up in the store, only whether
+// there's a low priority channel matters
+func (c *LocalConfig) filterFingerprint(search *Search) string {
+	var requirements *Requirements
+	lowPriority := false
+	if r := c.searchRequirements(search); r != nil {
+		filters := *r
+		filters.LowPriority = NotifierSettings{}
+		requirements = &filters
+		lowPriority = len(r.lowPriority) > 0
+	}
+
+	data, _ := json.Marshal(struct {
+		Rules        []*Rule
+		Requirements *Requirements
+		LowPriority  bool
+	}{c.searchRules(search), requirements, lowPriority})
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
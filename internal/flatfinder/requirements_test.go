@@ -0,0 +1,219 @@
+package flatfinder
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequirementsCheck(t *testing.T) {
+	requirements := &Requirements{
+		Fibre:        true,
+		MinSpeedMbps: 300,
+		MaxTravel:    map[string]string{"Work": "30m"},
+	}
+	err := requirements.init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fibre := EnrichedListing{Broadband: BroadbandInfo{Checked: true, MaxDownMbps: 900}}
+	fibre.TravelTimes = []TravelTime{{Destination: "Work", Duration: 20 * time.Minute}}
+
+	noFibre := fibre
+	noFibre.Broadband.FibreBuildRequired = true
+
+	slow := fibre
+	slow.Broadband.MaxDownMbps = 100
+
+	far := fibre
+	far.TravelTimes = []TravelTime{{Destination: "Work", Duration: 95 * time.Minute}}
+
+	unknown := EnrichedListing{}
+
+	lfcArea := noFibre
+	lfcArea.Broadband.FibreSupplier = "Enable"
+	lfcArea.Broadband.FibreUnconfirmed = true
+
+	tests := []struct {
+		name          string
+		listing       EnrichedListing
+		rejectUnknown bool
+		want          string
+	}{
+		{"passes", fibre, false, ""},
+		{"no fibre", noFibre, false, "no fibre"},
+		{"slow", slow, false, "max speed 100 Mbps, need 300 Mbps"},
+		{"far", far, false, "1h35m0s to Work, max 30m0s"},
+		{"unknown passes", unknown, false, ""},
+		{"unknown rejected", unknown, true, "broadband unknown"},
+		{"unconfirmed passes", lfcArea, false, ""},
+		{"unconfirmed rejected", lfcArea, true, "fibre unconfirmed in Enable area"},
+	}
+	for _, test := range tests {
+		requirements.RejectUnknown = test.rejectUnknown
+		if got := requirements.check(test.listing); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	var none *Requirements
+	if got := none.check(noFibre); got != "" {
+		t.Errorf("no requirements: got %q", got)
+	}
+}
+
+func TestValidateRequirements(t *testing.T) {
+	requirements := &Requirements{
+		MaxTravel:   map[string]string{"Gym": "soon", "Work": "30m"},
+		LowPriority: NotifierSettings{Types: "discord"},
+	}
+
+	got := requirements.validate("requirements", []Destination{{Name: "Work", Address: "1 Lambton Quay"}})
+	want := []string{
+		"requirements: max_travel: unknown destination Gym",
+		"requirements: max_travel: Gym must be a duration like 30m",
+		"requirements.low_priority: discord_webhook (DISCORD_WEBHOOK) not set",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// A webhook on its own is enough, like a search's notifier settings
+	requirements = &Requirements{LowPriority: NotifierSettings{WebhookURL: "https://example.com/low"}}
+	if got := requirements.validate("requirements", nil); len(got) != 0 {
+		t.Fatalf("webhook only: %q", got)
+	}
+	err := requirements.init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requirements.lowPriority) != 1 || requirements.lowPriority[0].Name() != "webhook" {
+		t.Errorf("low priority notifiers %v, want the webhook", requirements.lowPriority)
+	}
+}
+
+func TestRequirementsLowPriority(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, notifier := newTestConfig(t, fake)
+
+	// Chorus answers but can't find any of the addresses, so broadband is never known
+	chorus := newFakeChorus(t)
+	chorus.searchFile = "address_search_none.json"
+	chorusHTTP = &http.Client{}
+
+	c.Requirements = &Requirements{Fibre: true, RejectUnknown: true}
+	err := c.Requirements.init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lowPriority := &recordingNotifier{}
+	c.Requirements.lowPriority = []Notifier{lowPriority}
+
+	err = c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := notifier.sent(); len(got) != 0 {
+		t.Fatalf("sent %q to the main notifiers", got)
+	}
+	if got := lowPriority.sent(); len(got) != 3 {
+		t.Fatalf("sent %q to low priority, want all 3", got)
+	}
+	if reason := lowPriority.listings[0].LowPriority; reason != "broadband: address uncertain" {
+		t.Errorf("reason %q", reason)
+	}
+
+	stored, err := c.Store.GetListing(4211000101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := stored.Notifications["test"]; status.LowPriority != "broadband: address uncertain" {
+		t.Errorf("notification %+v", status)
+	}
+
+	// Without a low priority channel they're dropped
+	c.Searches[0].Name = "strict"
+	c.Requirements.lowPriority = nil
+	c.Requirements.LowPriority.DiscordWebhook = "https://discord.com/api/webhooks/1/secret"
+	err = c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(lowPriority.sent()) + len(notifier.sent()); got != 3 {
+		t.Fatalf("%d sent after dropping, want still 3", got)
+	}
+	stored, err = c.Store.GetListing(4211000101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejection := stored.Rejections["strict"]
+	if rejection.Rule != "requirement: broadband: address uncertain" {
+		t.Errorf("rejection %+v", rejection)
+	}
+
+	// Only a hash of the filters is kept, never the webhook
+	if len(rejection.Rules) != 64 || strings.Contains(rejection.Rules, "secret") {
+		t.Errorf("rejection rules %q", rejection.Rules)
+	}
+}
+
+func TestRequirementsLookupFailed(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, notifier := newTestConfig(t, fake)
+
+	// The search fixture's pin is a couple of hundred metres from Chorus' for 12 Cuba Street
+	c.ChorusMatchDistance = 500
+
+	// Chorus fails in tests, so nothing is known until it's back
+	c.Requirements = &Requirements{Fibre: true, RejectUnknown: true}
+	err := c.Requirements.init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := notifier.sent(); len(got) != 0 {
+		t.Fatalf("sent %q while Chorus was down", got)
+	}
+
+	// Not remembered as rejected or looked up, and the same window is searched again
+	stored, err := c.Store.GetListing(4211000101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Rejections) != 0 || !stored.EnrichedAt.IsZero() {
+		t.Fatalf("failed lookup kept: rejections %+v, enriched at %s", stored.Rejections, stored.EnrichedAt)
+	}
+	if lastPoll, _ := c.Store.LastPoll("test"); !lastPoll.IsZero() {
+		t.Errorf("last poll moved on past held listings")
+	}
+
+	// Back up, the one with fibre is sent and the rest really are unknown
+	newFakeChorus(t)
+	chorusHTTP = &http.Client{}
+	err = c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := notifier.sent(); !reflect.DeepEqual(got, []string{"Sunny two bedroom townhouse"}) {
+		t.Fatalf("sent %q after Chorus recovered", got)
+	}
+	stored, err = c.Store.GetListing(4211000102)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rejection := stored.Rejections["test"]; rejection.Rule != "requirement: broadband: address uncertain" {
+		t.Errorf("rejection %+v", rejection)
+	}
+	if lastPoll, _ := c.Store.LastPoll("test"); lastPoll.IsZero() {
+		t.Errorf("last poll not recorded once lookups worked")
+	}
+}
@@ -0,0 +1,352 @@
+package flatfinder
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Rule - A client side filter on listing fields, one of all/any/not/field/keywords
+type Rule struct {
+	// Shown in the log instead of the rule itself when set
+	Name string `json:"name,omitempty"`
+
+	All []*Rule `json:"all,omitempty"`
+	Any []*Rule `json:"any,omitempty"`
+	Not *Rule   `json:"not,omitempty"`
+
+	// Compare a listing field, e.g. {"field": "Bathrooms", "op": ">=", "value": 2}
+	Field string      `json:"field,omitempty"`
+	Op    string      `json:"op,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+
+	// Words in the title or body, case insensitive
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// RuleRejection - Which rule stopped a listing being sent for a search
+type RuleRejection struct {
+	At          time.Time `json:"at"`
+	Rule        string    `json:"rule"`
+	RentPerWeek int       `json:"rent_per_week"`
+
+	// Hash of the rules and requirements at the time, so editing them looks again
+	Rules string `json:"rules"`
+}
+
+// ruleOps - Which ops each kind of field supports
+var ruleOps = map[string][]reflect.Kind{
+	"=":        {reflect.String, reflect.Int, reflect.Float64, reflect.Bool},
+	"!=":       {reflect.String, reflect.Int, reflect.Float64, reflect.Bool},
+	">":        {reflect.Int, reflect.Float64},
+	">=":       {reflect.Int, reflect.Float64},
+	"<":        {reflect.Int, reflect.Float64},
+	"<=":       {reflect.Int, reflect.Float64},
+	"contains": {reflect.String},
+	"in":       {reflect.String, reflect.Int},
+}
+
+// ruleBodyField - Not on the search listing, only there once details are fetched
+const ruleBodyField = "Body"
+
+// checkRules - Every rule must pass, returns the one that rejected the listing or ""
+func checkRules(rules []*Rule, listing EnrichedListing) string {
+	for _, rule := range rules {
+		if rejected := rule.check(listing); rejected != "" {
+			return rejected
+		}
+	}
+
+	return ""
+}
+
+// check - "" if the listing passes, otherwise why it didn't
+func (r *Rule) check(listing EnrichedListing) string {
+	rejected := r.reject(listing)
+	if rejected != "" && r.Name != "" {
+		return fmt.Sprintf("%s (%s)", r.Name, rejected)
+	}
+
+	return rejected
+}
+
+func (r *Rule) reject(listing EnrichedListing) string {
+	switch {
+	case len(r.All) > 0:
+		return checkRules(r.All, listing)
+	case len(r.Any) > 0:
+		reasons := []string{}
+		for _, rule := range r.Any {
+			rejected := rule.check(listing)
+			if rejected == "" {
+				return ""
+			}
+			reasons = append(reasons, rejected)
+		}
+		return "none of: " + strings.Join(reasons, "; ")
+	case r.Not != nil:
+		if r.Not.check(listing) == "" {
+			return "not " + r.Not.String()
+		}
+		return ""
+	case r.Field != "":
+		if !r.compare(ruleFieldValue(listing, r.Field)) {
+			return r.String()
+		}
+		return ""
+	default:
+		return r.checkKeywords(listing)
+	}
+}
+
+// checkKeywords - Any include must appear and no exclude may
+func (r *Rule) checkKeywords(listing EnrichedListing) string {
+	text := strings.ToLower(listing.Title)
+	if listing.Details != nil {
+		text += "\n" + strings.ToLower(listing.Details.Body)
+	}
+
+	for _, keyword := range r.Exclude {
+		if strings.Contains(text, strings.ToLower(keyword)) {
+			return fmt.Sprintf("exclude %q", keyword)
+		}
+	}
+
+	if len(r.Include) == 0 {
+		return ""
+	}
+	for _, keyword := range r.Include {
+		if strings.Contains(text, strings.ToLower(keyword)) {
+			return ""
+		}
+	}
+
+	return fmt.Sprintf("include %q", r.Include)
+}
+
+// compare - Field value against the rule, validation already checked the types
+func (r *Rule) compare(field reflect.Value) bool {
+	if r.Op == "in" {
+		for _, value := range r.Value.([]interface{}) {
+			if compareValue("=", field, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return compareValue(r.Op, field, r.Value)
+}
+
+// compareValue - One comparison, strings are case insensitive
+func compareValue(op string, field reflect.Value, value interface{}) bool {
+	switch field.Kind() {
+	case reflect.String:
+		a := strings.ToLower(strings.TrimSpace(field.String()))
+		b := strings.ToLower(strings.TrimSpace(value.(string)))
+		switch op {
+		case "=":
+			return a == b
+		case "!=":
+			return a != b
+		case "contains":
+			return strings.Contains(a, b)
+		}
+	case reflect.Bool:
+		switch op {
+		case "=":
+			return field.Bool() == value.(bool)
+		case "!=":
+			return field.Bool() != value.(bool)
+		}
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		var a float64
+		if field.Kind() == reflect.Float64 {
+			a = field.Float()
+		} else {
+			a = float64(field.Int())
+		}
+		b := value.(float64)
+		switch op {
+		case "=":
+			return a == b
+		case "!=":
+			return a != b
+		case ">":
+			return a > b
+		case ">=":
+			return a >= b
+		case "<":
+			return a < b
+		case "<=":
+			return a <= b
+		}
+	}
+
+	return false
+}
+
+// String - How the rule reads in the log
+func (r *Rule) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	switch {
+	case len(r.All) > 0:
+		return "all of " + rulesString(r.All)
+	case len(r.Any) > 0:
+		return "any of " + rulesString(r.Any)
+	case r.Not != nil:
+		return "not " + r.Not.String()
+	case r.Field != "":
+		return fmt.Sprintf("%s %s %v", r.Field, r.Op, r.Value)
+	default:
+		return fmt.Sprintf("include %q exclude %q", r.Include, r.Exclude)
+	}
+}
+
+func rulesString(rules []*Rule) string {
+	parts := []string{}
+	for _, rule := range rules {
+		parts = append(parts, rule.String())
+	}
+
+	return "(" + strings.Join(parts, "; ") + ")"
+}
+
+// ruleFieldValue - Look a field up by Go or JSON name, dots for nested fields
+func ruleFieldValue(listing EnrichedListing, path string) reflect.Value {
+	if strings.EqualFold(path, ruleBodyField) {
+		if listing.Details == nil {
+			return reflect.ValueOf("")
+		}
+		return reflect.ValueOf(listing.Details.Body)
+	}
+
+	value := reflect.ValueOf(listing.TradeMeListing)
+	for _, name := range strings.Split(path, ".") {
+		value = ruleStructField(value, name)
+		if !value.IsValid() {
+			return value
+		}
+	}
+
+	return value
+}
+
+// ruleStructField - Case insensitive match on the field or its JSON name
+func ruleStructField(value reflect.Value, name string) reflect.Value {
+	if value.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+		if strings.EqualFold(field.Name, name) || strings.EqualFold(jsonName, name) {
+			return value.Field(i)
+		}
+	}
+
+	return reflect.Value{}
+}
+
+// ruleFieldKind - Kind a rule compares the field as, Invalid if it can't
+func ruleFieldKind(path string) reflect.Kind {
+	field := ruleFieldValue(EnrichedListing{}, path)
+	if !field.IsValid() {
+		return reflect.Invalid
+	}
+
+	switch field.Kind() {
+	case reflect.Int, reflect.Int64:
+		return reflect.Int
+	case reflect.Float64, reflect.String, reflect.Bool:
+		return field.Kind()
+	}
+
+	return reflect.Invalid
+}
+
+// validate - List every problem with a rule and the rules under it
+func (r *Rule) validate(path string) []string {
+	if r == nil {
+		return []string{path + ": can't be null"}
+	}
+
+	problems := []string{}
+
+	kinds := 0
+	for _, set := range []bool{len(r.All) > 0, len(r.Any) > 0, r.Not != nil, r.Field != "", len(r.Include) > 0 || len(r.Exclude) > 0} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return append(problems, path+": needs exactly one of all, any, not, field or include/exclude")
+	}
+
+	for i, rule := range r.All {
+		problems = append(problems, rule.validate(fmt.Sprintf("%s.all[%d]", path, i))...)
+	}
+	for i, rule := range r.Any {
+		problems = append(problems, rule.validate(fmt.Sprintf("%s.any[%d]", path, i))...)
+	}
+	if r.Not != nil {
+		problems = append(problems, r.Not.validate(path+".not")...)
+	}
+	if r.Field != "" {
+		problems = append(problems, r.validateField(path)...)
+	}
+
+	return problems
+}
+
+// validateField - Known field, an op it supports and a value of the right type
+func (r *Rule) validateField(path string) []string {
+	kind := ruleFieldKind(r.Field)
+	if kind == reflect.Invalid {
+		return []string{fmt.Sprintf("%s: unknown field %s", path, r.Field)}
+	}
+
+	supported, ok := ruleOps[r.Op]
+	if !ok {
+		return []string{fmt.Sprintf("%s: unknown op %q", path, r.Op)}
+	}
+	found := false
+	for _, k := range supported {
+		found = found || k == kind
+	}
+	if !found {
+		return []string{fmt.Sprintf("%s: op %s can't be used on %s", path, r.Op, r.Field)}
+	}
+
+	values := []interface{}{r.Value}
+	if r.Op == "in" {
+		list, ok := r.Value.([]interface{})
+		if !ok || len(list) == 0 {
+			return []string{fmt.Sprintf("%s: op in needs a list of values", path)}
+		}
+		values = list
+	}
+
+	for _, value := range values {
+		var ok bool
+		switch kind {
+		case reflect.String:
+			_, ok = value.(string)
+		case reflect.Bool:
+			_, ok = value.(bool)
+		default:
+			_, ok = value.(float64)
+		}
+		if !ok {
+			return []string{fmt.Sprintf("%s: value %v doesn't match the type of %s", path, value, r.Field)}
+		}
+	}
+
+	return nil
+}
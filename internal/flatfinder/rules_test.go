@@ -0,0 +1,124 @@
+package flatfinder
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCheckRules(t *testing.T) {
+	listing := EnrichedListing{TradeMeListing: TradeMeListing{
+		Title:     "Sunny townhouse, pets negotiable",
+		Address:   "12 Cuba Street",
+		Bathrooms: 1,
+		PetsOkay:  1,
+		Parking:   "Off street",
+	}}
+	listing.GeographicLocation.Latitude = -41.29
+	listing.Details = &TradeMeListingDetails{Body: "Heat pump and a sunny courtyard."}
+
+	tests := []struct {
+		rules string
+		want  string
+	}{
+		{`[{"field": "PetsOkay", "op": "=", "value": 1}]`, ""},
+		{`[{"field": "bathrooms", "op": ">=", "value": 2}]`, "bathrooms >= 2"},
+		{`[{"field": "Parking", "op": "contains", "value": "off street"}]`, ""},
+		{`[{"field": "GeographicLocation.Latitude", "op": "<", "value": -41}]`, ""},
+		{`[{"not": {"field": "Address", "op": "contains", "value": "cuba street"}}]`, "not Address contains cuba street"},
+		{`[{"name": "No Cuba St", "not": {"field": "Address", "op": "contains", "value": "cuba street"}}]`, "No Cuba St (not Address contains cuba street)"},
+		{`[{"field": "Address", "op": "in", "value": ["1 Main Road", "12 Cuba Street"]}]`, ""},
+		{`[{"include": ["heat pump"]}, {"exclude": ["no pets"]}]`, ""},
+		{`[{"exclude": ["courtyard"]}]`, `exclude "courtyard"`},
+		{`[{"field": "Body", "op": "contains", "value": "heat pump"}]`, ""},
+		{`[{"any": [{"field": "Bathrooms", "op": ">=", "value": 2}, {"field": "Bedrooms", "op": ">=", "value": 3}]}]`, "none of: Bathrooms >= 2; Bedrooms >= 3"},
+		{`[{"all": [{"field": "PetsOkay", "op": "=", "value": 1}, {"field": "Bathrooms", "op": ">", "value": 1}]}]`, "Bathrooms > 1"},
+	}
+	for _, test := range tests {
+		var rules []*Rule
+		err := json.Unmarshal([]byte(test.rules), &rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, rule := range rules {
+			if problems := rule.validate("rules"); len(problems) > 0 {
+				t.Fatalf("%s: rule %d invalid: %q", test.rules, i, problems)
+			}
+		}
+
+		if got := checkRules(rules, listing); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.rules, got, test.want)
+		}
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	tests := []struct {
+		rule string
+		want []string
+	}{
+		{`{"field": "Rooms", "op": "=", "value": 1}`, []string{"r: unknown field Rooms"}},
+		{`{"field": "Bathrooms", "op": "~", "value": 1}`, []string{`r: unknown op "~"`}},
+		{`{"field": "Address", "op": ">", "value": "a"}`, []string{"r: op > can't be used on Address"}},
+		{`{"field": "Bathrooms", "op": "=", "value": "two"}`, []string{"r: value two doesn't match the type of Bathrooms"}},
+		{`{"field": "Bathrooms", "op": "in", "value": 2}`, []string{"r: op in needs a list of values"}},
+		{`{"field": "Bathrooms", "op": "=", "value": 2, "include": ["x"]}`, []string{"r: needs exactly one of all, any, not, field or include/exclude"}},
+		{`{"any": [{}, {"field": "Bathrooms", "op": "=", "value": 2}]}`, []string{"r.any[0]: needs exactly one of all, any, not, field or include/exclude"}},
+		{`{"all": [{"include": ["x"]}, null]}`, []string{"r.all[1]: can't be null"}},
+		{`{"not": {"any": [null]}}`, []string{"r.not.any[0]: can't be null"}},
+	}
+	for _, test := range tests {
+		var rule Rule
+		err := json.Unmarshal([]byte(test.rule), &rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := rule.validate("r"); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.rule, got, test.want)
+		}
+	}
+
+	// A null at the top level of rules
+	var rule *Rule
+	if got := rule.validate("rules[0]"); !reflect.DeepEqual(got, []string{"rules[0]: can't be null"}) {
+		t.Errorf("null rule: got %q", got)
+	}
+}
+
+func TestSearchRules(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, notifier := newTestConfig(t, fake)
+	c.Rules = []*Rule{{Field: "PropertyType", Op: "!=", Value: "Apartment"}}
+	c.Searches[0].Rules = []*Rule{{Name: "Pets", Field: "PetsOkay", Op: "!=", Value: float64(1)}}
+
+	err := c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"Sunny two bedroom townhouse"}
+	if got := notifier.sent(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("sent %q, want %q", got, want)
+	}
+
+	stored, err := c.Store.GetListing(4211000103)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rejection := stored.Rejections["test"]; rejection.Rule != "PropertyType != Apartment" {
+		t.Fatalf("rejection %+v", rejection)
+	}
+
+	// Loosening the rules looks at rejected listings again
+	c.Rules = nil
+	err = c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, "Modern apartment with views")
+	if got := notifier.sent(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("sent %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,146 @@
+package flatfinder
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScoreWeights - How much each part counts towards a listing's score, 0 ignores it
+type ScoreWeights struct {
+	Price     float64 `json:"price"`
+	Bedrooms  float64 `json:"bedrooms"`
+	Bathrooms float64 `json:"bathrooms"`
+	Parking   float64 `json:"parking"`
+	Fibre     float64 `json:"fibre"`
+	Travel    float64 `json:"travel"`
+}
+
+// DefaultScoreWeights - Used when scoring.weights isn't set
+var DefaultScoreWeights = ScoreWeights{
+	Price:     3,
+	Bedrooms:  1,
+	Bathrooms: 1,
+	Parking:   1,
+	Fibre:     1,
+	Travel:    2,
+}
+
+// DefaultTravelScoreMax - Travel this long or longer scores nothing
+var DefaultTravelScoreMax = time.Hour
+
+// scoreListing - 0 to 100, higher is better. Anything unknown scores half
+func (c *LocalConfig) scoreListing(search *Search, listing EnrichedListing) float64 {
+	weights := c.ScoreWeights
+	parts := []struct {
+		weight float64
+		score  float64
+	}{
+		{weights.Price, scorePrice(listing.RentPerWeek, search.PriceMax)},
+		{weights.Bedrooms, scoreBedrooms(listing.Bedrooms, search.BedroomsMin, search.BedroomsMax)},
+		{weights.Bathrooms, scoreBathrooms(listing.Bathrooms)},
+		{weights.Parking, scoreParking(listing)},
+		{weights.Fibre, scoreFibre(listing)},
+		{weights.Travel, scoreTravel(listing.TravelTimes, c.TravelScoreMax)},
+	}
+
+	total, score := 0.0, 0.0
+	for _, part := range parts {
+		total += part.weight
+		score += part.weight * part.score
+	}
+	if total == 0 {
+		return 0
+	}
+
+	return math.Round(100 * score / total)
+}
+
+// scoreRange - Where value sits between low (0) and high (1)
+func scoreRange(value float64, low float64, high float64) float64 {
+	if high <= low {
+		if value >= low {
+			return 1
+		}
+		return 0
+	}
+
+	return math.Max(0, math.Min(1, (value-low)/(high-low)))
+}
+
+// scorePrice - Free scores 1, rent at the search's max scores 0
+func scorePrice(rent int, priceMax string) float64 {
+	max, err := strconv.Atoi(priceMax)
+	if err != nil || max <= 0 || rent <= 0 {
+		return 0.5
+	}
+
+	return 1 - scoreRange(float64(rent), 0, float64(max))
+}
+
+// scoreBedrooms - The search's min bedrooms scores 0, its max 1
+func scoreBedrooms(bedrooms int, bedroomsMin string, bedroomsMax string) float64 {
+	min, errMin := strconv.Atoi(bedroomsMin)
+	max, errMax := strconv.Atoi(bedroomsMax)
+	if errMin != nil || errMax != nil || bedrooms <= 0 {
+		return 0.5
+	}
+
+	return scoreRange(float64(bedrooms), float64(min), float64(max))
+}
+
+// scoreBathrooms - One scores 0, three or more 1
+func scoreBathrooms(bathrooms int) float64 {
+	if bathrooms <= 0 {
+		return 0.5
+	}
+
+	return scoreRange(float64(bathrooms), 1, 3)
+}
+
+// scoreParking - Any off street parking, half if the listing doesn't say
+func scoreParking(listing EnrichedListing) float64 {
+	parking := strings.ToLower(listing.Parking)
+	if listing.TotalParking > 0 || strings.Contains(parking, "off street") || strings.Contains(parking, "garage") {
+		return 1
+	}
+	if strings.TrimSpace(parking) == "" {
+		return 0.5
+	}
+
+	return 0
+}
+
+// scoreFibre - Fibre available scores 1, unconfirmed in another company's area scores half
+func scoreFibre(listing EnrichedListing) float64 {
+	if !listing.Broadband.Checked || listing.Broadband.FibreUnconfirmed {
+		return 0.5
+	}
+	if listing.Broadband.FibreAvailable() {
+		return 1
+	}
+
+	return 0
+}
+
+// scoreTravel - Average over destinations, none scores 1 and max or longer 0
+func scoreTravel(travelTimes []TravelTime, max time.Duration) float64 {
+	if max <= 0 {
+		max = DefaultTravelScoreMax
+	}
+
+	total, known := 0.0, 0
+	for _, travelTime := range travelTimes {
+		if travelTime.Duration == 0 {
+			continue
+		}
+		total += 1 - scoreRange(float64(travelTime.Duration), 0, float64(max))
+		known++
+	}
+	if known == 0 {
+		return 0.5
+	}
+
+	return total / float64(known)
+}
@@ -0,0 +1,98 @@
+package flatfinder
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScoreListing(t *testing.T) {
+	c := &LocalConfig{ScoreWeights: DefaultScoreWeights, TravelScoreMax: time.Hour}
+	search := &Search{PriceMax: "800", BedroomsMin: "1", BedroomsMax: "3"}
+
+	best := EnrichedListing{
+		Broadband:   BroadbandInfo{Checked: true},
+		TravelTimes: []TravelTime{{Destination: "Work", Duration: time.Minute}},
+	}
+	best.RentPerWeek = 1
+	best.Bedrooms = 3
+	best.Bathrooms = 3
+	best.TotalParking = 1
+
+	worst := EnrichedListing{
+		Broadband:   BroadbandInfo{Checked: true, FibreBuildRequired: true},
+		TravelTimes: []TravelTime{{Destination: "Work", Duration: 2 * time.Hour}},
+	}
+	worst.RentPerWeek = 800
+	worst.Bedrooms = 1
+	worst.Bathrooms = 1
+	worst.Parking = "On street"
+
+	// Nothing known scores half on everything
+	tests := []struct {
+		name    string
+		listing EnrichedListing
+		want    float64
+	}{
+		{"best", best, 100},
+		{"worst", worst, 0},
+		{"unknown", EnrichedListing{}, 50},
+	}
+	for _, test := range tests {
+		if got := c.scoreListing(search, test.listing); got != test.want {
+			t.Errorf("%s: got %.0f, want %.0f", test.name, got, test.want)
+		}
+	}
+
+	// Only price counts
+	c.ScoreWeights = ScoreWeights{Price: 1}
+	half := EnrichedListing{}
+	half.RentPerWeek = 400
+	if got := c.scoreListing(search, half); got != 50 {
+		t.Errorf("price only: got %.0f, want 50", got)
+	}
+}
+
+func TestSendDigests(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, notifier := newTestConfig(t, fake)
+	c.ScoreWeights = ScoreWeights{Price: 1}
+	c.DigestEvery = time.Hour
+	c.DigestSize = 2
+
+	err := c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := len(notifier.sent())
+
+	// Imported from flatfinder.json, nothing to show until a listing check fills it in
+	err = c.Store.PutListing(&StoredListing{
+		Listing:       TradeMeListing{ListingID: 4211000999},
+		FirstSeen:     time.Now(),
+		Notifications: map[string]NotificationStatus{"test": {SentAt: time.Now()}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.sendDigests(context.Background())
+
+	// Cheapest first, cut to the digest size
+	digest := notifier.listings[sent:]
+	if len(digest) != 2 {
+		t.Fatalf("digest of %d listings, want 2", len(digest))
+	}
+	if digest[0].Title != "Character flat close to town" || digest[1].Title != "Sunny two bedroom townhouse" {
+		t.Errorf("digest order %q, %q", digest[0].Title, digest[1].Title)
+	}
+	if digest[0].Alert != AlertDigest || digest[0].Score <= digest[1].Score {
+		t.Errorf("digest listing %s scored %.0f then %.0f", digest[0].Alert, digest[0].Score, digest[1].Score)
+	}
+
+	// Not again until DigestEvery has passed
+	c.sendDigests(context.Background())
+	if got := len(notifier.sent()); got != sent+2 {
+		t.Fatalf("%d sent after second digest, want %d", got, sent+2)
+	}
+}
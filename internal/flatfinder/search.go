@@ -0,0 +1,138 @@
+package flatfinder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Search - A named set of Trade Me filters and where to send the results
+type Search struct {
+	Name          string `json:"name"`
+	Suburbs       string `json:"suburbs"`
+	BedroomsMin   string `json:"bedrooms_min"`
+	BedroomsMax   string `json:"bedrooms_max"`
+	PriceMax      string `json:"price_max"`
+	PropertyTypes string `json:"property_type"`
+
+	// Checked after fetching, on top of the global rules
+	Rules []*Rule `json:"rules,omitempty"`
+
+	// Replaces the global requirements when set
+	Requirements *Requirements `json:"requirements,omitempty"`
+
+	// Blank fields fall back to the global notifier settings
+	NotifierSettings
+
+	Notifiers []Notifier `json:"-"`
+}
+
+// initSearches - Build notifiers for every search
+func (c *LocalConfig) initSearches() error {
+	if c.Requirements != nil {
+		err := c.Requirements.init()
+		if err != nil {
+			return fmt.Errorf("Requirements: %s", err)
+		}
+	}
+
+	for _, search := range c.Searches {
+		err := c.initSearchNotifiers(search)
+		if err != nil {
+			return fmt.Errorf("Search %s: %s", search.Name, err)
+		}
+
+		if search.Requirements != nil {
+			err = search.Requirements.init()
+			if err != nil {
+				return fmt.Errorf("Search %s: requirements: %s", search.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validate - List every missing filter on a search
+func (s *Search) validate(index int) []string {
+	problems := []string{}
+
+	name := s.Name
+	if name == "" {
+		name = fmt.Sprintf("searches[%d]", index)
+		problems = append(problems, name+": name not set")
+	}
+
+	required := []struct {
+		key   string
+		value string
+	}{
+		{"suburbs (SUBURBS)", s.Suburbs},
+		{"bedrooms_min (BEDROOMS_MIN)", s.BedroomsMin},
+		{"bedrooms_max (BEDROOMS_MAX)", s.BedroomsMax},
+		{"price_max (PRICE_MAX)", s.PriceMax},
+		{"property_type (PROPERTY_TYPE)", s.PropertyTypes},
+	}
+	for _, field := range required {
+		if field.value == "" {
+			problems = append(problems, fmt.Sprintf("%s: %s not set", name, field.key))
+		}
+	}
+
+	for i, rule := range s.Rules {
+		problems = append(problems, rule.validate(fmt.Sprintf("%s: rules[%d]", name, i))...)
+	}
+
+	return problems
+}
+
+// resolveNotifierSettings - Fill blank search settings from the global ones
+func (s *Search) resolveNotifierSettings(global NotifierSettings) NotifierSettings {
+	settings := s.NotifierSettings
+	if settings == (NotifierSettings{}) {
+		return global
+	}
+
+	settings = settings.inferTypes()
+	if settings.DiscordWebhook == "" {
+		settings.DiscordWebhook = global.DiscordWebhook
+	}
+	if settings.DiscordTag == "" {
+		settings.DiscordTag = global.DiscordTag
+	}
+	if settings.WebhookURL == "" {
+		settings.WebhookURL = global.WebhookURL
+	}
+
+	return settings
+}
+
+// initSearchNotifiers - Use the search's own targets, or share the global ones
+func (c *LocalConfig) initSearchNotifiers(search *Search) error {
+	if search.NotifierSettings == (NotifierSettings{}) {
+		search.Notifiers = c.Notifiers
+		return nil
+	}
+
+	notifiers, err := search.resolveNotifierSettings(c.Notify).buildNotifiers()
+	if err != nil {
+		return err
+	}
+
+	search.Notifiers = notifiers
+	return nil
+}
+
+// searchRules - Global rules then the search's own
+func (c *LocalConfig) searchRules(search *Search) []*Rule {
+	return append(append([]*Rule{}, c.Rules...), search.Rules...)
+}
+
+// searchNames - Comma separated names for logging
+func (c *LocalConfig) searchNames() string {
+	names := []string{}
+	for _, search := range c.Searches {
+		names = append(names, search.Name)
+	}
+
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,220 @@
+package flatfinder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// StoreBackups - How many rotated backups of the listing database to keep
+var StoreBackups = 3
+
+// writeFileAtomic - Write to a temp file, fsync, then rename over path
+func writeFileAtomic(path string, perm os.FileMode, write func(w io.Writer) error) error {
+	tmp, err := writeTempFile(path, perm, write)
+	if err != nil {
+		return err
+	}
+
+	// Clean up on any failure, a no-op once renamed
+	defer os.Remove(tmp)
+
+	return renameSynced(tmp, path)
+}
+
+// writeTempFile - Write and fsync a temp file next to path, returning its name
+func writeTempFile(path string, perm os.FileMode, write func(w io.Writer) error) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+
+	err = write(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// renameSynced - Rename a file into place and fsync the directory
+func renameSynced(from string, to string) error {
+	err := os.Rename(from, to)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(to))
+}
+
+// syncDir - fsync a directory so a rename survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// backupPath - flatfinder.db.bak, flatfinder.db.bak.1, ...
+func backupPath(path string, n int) string {
+	if n == 0 {
+		return path + ".bak"
+	}
+
+	return fmt.Sprintf("%s.bak.%d", path, n)
+}
+
+// Backup - Snapshot the database next to it, rotating older backups once the snapshot is written
+func (s *Store) Backup() error {
+	path := s.db.Path()
+
+	var snapshot string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		snapshot, err = writeTempFile(backupPath(path, 0), 0644, func(w io.Writer) error {
+			_, err := tx.WriteTo(w)
+			return err
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer os.Remove(snapshot)
+
+	for n := StoreBackups - 1; n > 0; n-- {
+		if fileExists(backupPath(path, n-1)) {
+			err := os.Rename(backupPath(path, n-1), backupPath(path, n))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return renameSynced(snapshot, backupPath(path, 0))
+}
+
+// storeDamaged - bbolt open errors that mean the file itself is bad, rather than
+// locked or unreadable
+func storeDamaged(err error) bool {
+	// bbolt doesn't export the truncated file error
+	return errors.Is(err, bolt.ErrInvalid) || errors.Is(err, bolt.ErrChecksum) ||
+		errors.Is(err, bolt.ErrVersionMismatch) || err != nil && err.Error() == "file size too small"
+}
+
+// checkStore - Open a database and verify every page. Damage is what's wrong with the
+// file, nil if it's good. Err means it couldn't be checked, e.g. another process has it locked
+func checkStore(path string) (damage error, err error) {
+	// Badly damaged files can panic bbolt rather than erroring
+	defer func() {
+		if r := recover(); r != nil {
+			damage = fmt.Errorf("%v", r)
+		}
+	}()
+
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: storeOpenTimeout, ReadOnly: true})
+	if storeDamaged(err) {
+		return err, nil
+	}
+	if err != nil {
+		return nil, storeOpenError(path, err)
+	}
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		// Drain every error so the checker finishes before the tx closes
+		for checkErr := range tx.Check() {
+			if damage == nil {
+				damage = checkErr
+			}
+		}
+		return nil
+	})
+
+	return damage, err
+}
+
+// OpenStoreSafely - Open the store, restoring the newest good backup if it's corrupt.
+// A locked or unreadable database is an error, only damage found in the file restores
+func OpenStoreSafely(path string) (*Store, error) {
+	if !fileExists(path) {
+		return OpenStore(path)
+	}
+
+	damage, err := checkStore(path)
+	if err != nil {
+		return nil, err
+	}
+	if damage == nil {
+		return OpenStore(path)
+	}
+	log.Printf("%s is corrupt: %s", path, damage)
+
+	// Never start fresh over history, that would re-announce everything
+	for n := 0; n < StoreBackups; n++ {
+		backup := backupPath(path, n)
+		if !fileExists(backup) {
+			continue
+		}
+
+		backupDamage, backupErr := checkStore(backup)
+		if backupErr != nil {
+			log.Printf("Skipping backup %s: %s", backup, backupErr)
+			continue
+		}
+		if backupDamage != nil {
+			log.Printf("Backup %s is also corrupt: %s", backup, backupDamage)
+			continue
+		}
+
+		err = restoreBackup(path, backup)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("Restored %s from %s, listings since that backup may be announced again", path, backup)
+		return OpenStore(path)
+	}
+
+	return nil, errors.New("Refusing to start: " + path + " is corrupt and there's no good backup. Move it aside to start fresh")
+}
+
+// restoreBackup - Keep the corrupt file for inspection and copy the backup in
+func restoreBackup(path string, backup string) error {
+	corrupt := fmt.Sprintf("%s.corrupt-%d", path, time.Now().Unix())
+	err := os.Rename(path, corrupt)
+	if err != nil {
+		return err
+	}
+	log.Printf("Moved corrupt database to %s", corrupt)
+
+	src, err := os.Open(backup)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return writeFileAtomic(path, 0644, func(w io.Writer) error {
+		_, err := io.Copy(w, src)
+		return err
+	})
+}
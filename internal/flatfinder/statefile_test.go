@@ -0,0 +1,118 @@
+package flatfinder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestStore - A store with one listing and a backup of it
+func newTestStore(t *testing.T) (*Store, string) {
+	path := filepath.Join(t.TempDir(), "flatfinder.db")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.PutListing(&StoredListing{Listing: TradeMeListing{ListingID: 1}})
+	if err == nil {
+		err = store.Backup()
+	}
+	if err != nil {
+		store.Close()
+		t.Fatal(err)
+	}
+
+	return store, path
+}
+
+func TestOpenStoreSafelyLocked(t *testing.T) {
+	realTimeout := storeOpenTimeout
+	storeOpenTimeout = 100 * time.Millisecond
+	defer func() { storeOpenTimeout = realTimeout }()
+
+	store, path := newTestStore(t)
+	defer store.Close()
+
+	// Another instance has it open, that's not corruption
+	_, err := OpenStoreSafely(path)
+	if err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Fatalf("opened a locked store: %v", err)
+	}
+
+	corrupt, _ := filepath.Glob(path + ".corrupt-*")
+	if len(corrupt) != 0 {
+		t.Fatalf("locked store moved aside: %q", corrupt)
+	}
+	listing, err := store.GetListing(1)
+	if err != nil || listing == nil {
+		t.Fatalf("live store lost its listing: %v %v", listing, err)
+	}
+}
+
+func TestOpenStoreSafelyCorrupt(t *testing.T) {
+	store, path := newTestStore(t)
+
+	// Listing after the backup is lost with the damaged file
+	err := store.PutListing(&StoredListing{Listing: TradeMeListing{ListingID: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// Zero both meta pages
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err == nil {
+		_, err = file.WriteAt(make([]byte, 2*os.Getpagesize()), 0)
+		file.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenStoreSafely(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if listing, err := store.GetListing(1); err != nil || listing == nil {
+		t.Errorf("backup not restored: %v %v", listing, err)
+	}
+	if listing, _ := store.GetListing(2); listing != nil {
+		t.Errorf("listing after the backup survived the restore")
+	}
+
+	corrupt, _ := filepath.Glob(path + ".corrupt-*")
+	if len(corrupt) != 1 {
+		t.Errorf("corrupt file not kept: %q", corrupt)
+	}
+}
+
+func TestStoreBackupRotation(t *testing.T) {
+	store, path := newTestStore(t)
+	defer store.Close()
+
+	for i := 0; i < StoreBackups+1; i++ {
+		err := store.Backup()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for n := 0; n < StoreBackups; n++ {
+		if !fileExists(backupPath(path, n)) {
+			t.Errorf("%s missing", backupPath(path, n))
+		}
+	}
+	if fileExists(backupPath(path, StoreBackups)) {
+		t.Errorf("kept more than %d backups", StoreBackups)
+	}
+
+	temps, _ := filepath.Glob(path + ".bak.tmp-*")
+	if len(temps) != 0 {
+		t.Errorf("temp snapshots left behind: %q", temps)
+	}
+}
@@ -0,0 +1,519 @@
+package flatfinder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	listingsBucket   = []byte("listings")
+	propertiesBucket = []byte("properties")
+	pollsBucket      = []byte("polls")
+	digestsBucket    = []byte("digests")
+)
+
+// Store - Persistent record of every listing we've seen
+type Store struct {
+	db *bolt.DB
+
+	// Guards read-modify-write of a listing
+	mu sync.Mutex
+}
+
+// StoredListing - Everything we know about a listing
+type StoredListing struct {
+	Listing TradeMeListing `json:"listing"`
+
+	FirstSeen         time.Time `json:"first_seen"`
+	LastSeen          time.Time `json:"last_seen"`
+	FirstRentPerWeek  int       `json:"first_rent_per_week"`
+	FirstPriceDisplay string    `json:"first_price_display"`
+
+	// Every price we've seen, oldest first
+	PriceHistory []PricePoint `json:"price_history"`
+
+	// Enrichment results, zero EnrichedAt means not looked up yet
+	EnrichedAt  time.Time     `json:"enriched_at"`
+	Broadband   BroadbandInfo `json:"broadband"`
+	TravelTimes []TravelTime  `json:"travel_times"`
+
+	// Listing details, zero DetailsFetchedAt means not fetched yet
+	DetailsFetchedAt time.Time              `json:"details_fetched_at"`
+	Details          *TradeMeListingDetails `json:"details,omitempty"`
+
+	// Set once Trade Me says the listing is gone
+	LastCheckedAt time.Time `json:"last_checked_at"`
+	ClosedAt      time.Time `json:"closed_at"`
+
+	// Search name -> notification result
+	Notifications map[string]NotificationStatus `json:"notifications"`
+
+	// Search name -> the rule that stopped it being sent
+	Rejections map[string]RuleRejection `json:"rejections,omitempty"`
+}
+
+// PricePoint - Rent as of a point in time
+type PricePoint struct {
+	At           time.Time `json:"at"`
+	RentPerWeek  int       `json:"rent_per_week"`
+	PriceDisplay string    `json:"price_display"`
+}
+
+// NotificationStatus - When a listing was sent for a search, at what price, and what failed
+type NotificationStatus struct {
+	SentAt       time.Time `json:"sent_at"`
+	Failed       []string  `json:"failed,omitempty"`
+	RentPerWeek  int       `json:"rent_per_week,omitempty"`
+	PriceDisplay string    `json:"price_display,omitempty"`
+
+	// Requirement it missed if it went to the low priority notifiers
+	LowPriority string `json:"low_priority,omitempty"`
+
+	// What the alert said, kept while Failed isn't empty so it can be sent again
+	Pending *PendingAlert `json:"pending,omitempty"`
+}
+
+// PendingAlert - The parts of an alert that aren't on the stored listing
+type PendingAlert struct {
+	Alert                AlertKind `json:"alert"`
+	PreviousListingID    int64     `json:"previous_listing_id,omitempty"`
+	PreviousRentPerWeek  int       `json:"previous_rent_per_week,omitempty"`
+	PreviousPriceDisplay string    `json:"previous_price_display,omitempty"`
+}
+
+// storeOpenTimeout - How long to wait for another process to let go of the database
+var storeOpenTimeout = 5 * time.Second
+
+// OpenStore - Open (or create) the listing database
+func OpenStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: storeOpenTimeout})
+	if err != nil {
+		return nil, storeOpenError(path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{listingsBucket, propertiesBucket, pollsBucket, digestsBucket} {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// storeOpenError - Say plainly when the database is locked rather than "timeout"
+func storeOpenError(path string, err error) error {
+	if errors.Is(err, bolt.ErrTimeout) {
+		return fmt.Errorf("Failed to open %s: database is locked, is another flatfinder running?", path)
+	}
+
+	return fmt.Errorf("Failed to open %s: %s", path, err)
+}
+
+// Close - Flush and close the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// listingKey - Bucket key for a listing ID
+func listingKey(id int64) []byte {
+	return []byte(strconv.FormatInt(id, 10))
+}
+
+// GetListing - Load a stored listing, nil if we've never seen it
+func (s *Store) GetListing(id int64) (*StoredListing, error) {
+	var stored *StoredListing
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		stored, err = getListing(tx.Bucket(listingsBucket), id)
+		return err
+	})
+
+	return stored, err
+}
+
+// PutListing - Write a stored listing
+func (s *Store) PutListing(stored *StoredListing) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putListing(tx.Bucket(listingsBucket), stored)
+	})
+}
+
+// getListing - Decode a listing from the bucket
+func getListing(bucket *bolt.Bucket, id int64) (*StoredListing, error) {
+	data := bucket.Get(listingKey(id))
+	if data == nil {
+		return nil, nil
+	}
+
+	stored := &StoredListing{}
+	err := json.Unmarshal(data, stored)
+	return stored, err
+}
+
+// putListing - Encode a listing into the bucket
+func putListing(bucket *bolt.Bucket, stored *StoredListing) error {
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put(listingKey(stored.Listing.ListingID), data)
+}
+
+// SeenListing - Record a sighting of a listing, creating it if new
+func (s *Store) SeenListing(listing TradeMeListing) (*StoredListing, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.GetListing(listing.ListingID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if stored == nil {
+		stored = &StoredListing{FirstSeen: now}
+	}
+
+	// Migrated listings only had an ID, so take the price the first time we see it
+	if stored.FirstPriceDisplay == "" {
+		stored.FirstRentPerWeek = listing.RentPerWeek
+		stored.FirstPriceDisplay = listing.PriceDisplay
+	}
+	if stored.Notifications == nil {
+		stored.Notifications = make(map[string]NotificationStatus)
+	}
+
+	// Keep a history of price changes
+	last := len(stored.PriceHistory) - 1
+	if last < 0 || stored.PriceHistory[last].RentPerWeek != listing.RentPerWeek || stored.PriceHistory[last].PriceDisplay != listing.PriceDisplay {
+		stored.PriceHistory = append(stored.PriceHistory, PricePoint{
+			At:           now,
+			RentPerWeek:  listing.RentPerWeek,
+			PriceDisplay: listing.PriceDisplay,
+		})
+	}
+
+	stored.Listing = listing
+	stored.LastSeen = now
+	stored.ClosedAt = time.Time{}
+
+	return stored, s.db.Update(func(tx *bolt.Tx) error {
+		err := putListing(tx.Bucket(listingsBucket), stored)
+		if err != nil {
+			return err
+		}
+
+		return addPropertyListing(tx.Bucket(propertiesBucket), listing)
+	})
+}
+
+// addPropertyListing - Remember every listing ID a property has had
+func addPropertyListing(bucket *bolt.Bucket, listing TradeMeListing) error {
+	if listing.PropertyID == "" {
+		return nil
+	}
+
+	ids, err := getPropertyListings(bucket, listing.PropertyID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == listing.ListingID {
+			return nil
+		}
+	}
+
+	data, err := json.Marshal(append(ids, listing.ListingID))
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(listing.PropertyID), data)
+}
+
+// getPropertyListings - Listing IDs seen for a property
+func getPropertyListings(bucket *bolt.Bucket, propertyID string) ([]int64, error) {
+	ids := []int64{}
+
+	data := bucket.Get([]byte(propertyID))
+	if data == nil {
+		return ids, nil
+	}
+
+	err := json.Unmarshal(data, &ids)
+	return ids, err
+}
+
+// PreviousListings - Other listings of the same property
+func (s *Store) PreviousListings(listing TradeMeListing) ([]*StoredListing, error) {
+	previous := []*StoredListing{}
+	if listing.PropertyID == "" {
+		return previous, nil
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		ids, err := getPropertyListings(tx.Bucket(propertiesBucket), listing.PropertyID)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if id == listing.ListingID {
+				continue
+			}
+
+			stored, err := getListing(tx.Bucket(listingsBucket), id)
+			if err != nil {
+				return err
+			}
+			if stored != nil {
+				previous = append(previous, stored)
+			}
+		}
+
+		return nil
+	})
+
+	return previous, err
+}
+
+// OpenNotifiedListings - Listings we've sent that aren't known to be closed
+func (s *Store) OpenNotifiedListings() ([]*StoredListing, error) {
+	listings := []*StoredListing{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(listingsBucket).ForEach(func(k, v []byte) error {
+			stored := &StoredListing{}
+			err := json.Unmarshal(v, stored)
+			if err != nil {
+				return err
+			}
+
+			if len(stored.Notifications) > 0 && stored.ClosedAt.IsZero() {
+				listings = append(listings, stored)
+			}
+			return nil
+		})
+	})
+
+	return listings, err
+}
+
+// FailedNotifications - Listings with an alert that didn't reach every notifier
+func (s *Store) FailedNotifications() ([]*StoredListing, error) {
+	listings := []*StoredListing{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(listingsBucket).ForEach(func(k, v []byte) error {
+			stored := &StoredListing{}
+			err := json.Unmarshal(v, stored)
+			if err != nil {
+				return err
+			}
+
+			for _, status := range stored.Notifications {
+				if len(status.Failed) > 0 {
+					listings = append(listings, stored)
+					break
+				}
+			}
+			return nil
+		})
+	})
+
+	return listings, err
+}
+
+// LastPoll - When a search last completed, zero if never
+func (s *Store) LastPoll(search string) (time.Time, error) {
+	return s.getTime(pollsBucket, search)
+}
+
+// SetLastPoll - Record when a search last completed
+func (s *Store) SetLastPoll(search string, lastPoll time.Time) error {
+	return s.putTime(pollsBucket, search, lastPoll)
+}
+
+// LastDigest - When a search was last sent a digest, zero if never
+func (s *Store) LastDigest(search string) (time.Time, error) {
+	return s.getTime(digestsBucket, search)
+}
+
+// SetLastDigest - Record when a search was sent a digest
+func (s *Store) SetLastDigest(search string, sent time.Time) error {
+	return s.putTime(digestsBucket, search, sent)
+}
+
+// getTime - A time stored under a search name, zero if there isn't one
+func (s *Store) getTime(bucket []byte, search string) (time.Time, error) {
+	var t time.Time
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucket).Get([]byte(search))
+		if data == nil {
+			return nil
+		}
+
+		return t.UnmarshalText(data)
+	})
+
+	return t, err
+}
+
+// putTime - Store a time under a search name
+func (s *Store) putTime(bucket []byte, search string, t time.Time) error {
+	data, err := t.MarshalText()
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(search), data)
+	})
+}
+
+// CountListings - How many listings are stored
+func (s *Store) CountListings() int {
+	count := 0
+	s.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(listingsBucket).Stats().KeyN
+		return nil
+	})
+
+	return count
+}
+
+// notifiedFor - Have we already sent this listing for a search
+func (l *StoredListing) notifiedFor(search string) bool {
+	_, ok := l.Notifications[search]
+	return ok
+}
+
+// setEnrichment - Keep enrichment results so they're only looked up once, unless a lookup
+// failed in which case they're kept for now but looked up again next time
+func (l *StoredListing) setEnrichment(enriched EnrichedListing, err error) {
+	l.EnrichedAt = time.Time{}
+	if err == nil {
+		l.EnrichedAt = time.Now()
+	}
+	l.Broadband = enriched.Broadband
+	l.TravelTimes = enriched.TravelTimes
+}
+
+// copyEnrichment - Reuse results from another listing of the same property
+func (l *StoredListing) copyEnrichment(other *StoredListing) {
+	l.EnrichedAt = other.EnrichedAt
+	l.Broadband = other.Broadband
+	l.TravelTimes = other.TravelTimes
+}
+
+// enriched - Rebuild an enriched listing from stored results
+func (l *StoredListing) enriched() EnrichedListing {
+	enriched := EnrichedListing{
+		TradeMeListing: l.Listing,
+		Broadband:      l.Broadband,
+		TravelTimes:    l.TravelTimes,
+		Details:        l.Details,
+	}
+	if l.Details != nil {
+		l.Details.apply(&enriched.TradeMeListing)
+	}
+
+	return enriched
+}
+
+// legacyState - Layout of the old flatfinder.json
+type legacyState struct {
+	Searches   map[string]map[int64]bool `json:"searches"`
+	Properties map[int64]bool            `json:"properties"`
+}
+
+// migrateLegacyState - Import posted IDs from flatfinder.json, then move it aside
+func (s *Store) migrateLegacyState(path string, searches []*Search) error {
+	if !fileExists(path) {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var legacy legacyState
+	err = json.Unmarshal(data, &legacy)
+	if err != nil {
+		return fmt.Errorf("Failed to migrate %s: %s", path, err)
+	}
+
+	// Oldest format had one shared map, every search has seen those
+	if legacy.Searches == nil {
+		legacy.Searches = make(map[string]map[int64]bool)
+	}
+	for _, search := range searches {
+		if _, ok := legacy.Searches[search.Name]; !ok && len(legacy.Properties) > 0 {
+			legacy.Searches[search.Name] = legacy.Properties
+		}
+	}
+
+	// We don't know when they were sent, the file's mod time is the best guess
+	migrated := make(map[int64]bool)
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(listingsBucket)
+		for name, posted := range legacy.Searches {
+			for id := range posted {
+				stored, err := getListing(bucket, id)
+				if err != nil {
+					return err
+				}
+				if stored == nil {
+					stored = &StoredListing{
+						Listing:   TradeMeListing{ListingID: id},
+						FirstSeen: info.ModTime(),
+						LastSeen:  info.ModTime(),
+					}
+				}
+				if stored.Notifications == nil {
+					stored.Notifications = make(map[string]NotificationStatus)
+				}
+				stored.Notifications[name] = NotificationStatus{SentAt: info.ModTime()}
+
+				err = putListing(bucket, stored)
+				if err != nil {
+					return err
+				}
+				migrated[id] = true
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Migrated %d previously posted property IDs from %s", len(migrated), path)
+	return os.Rename(path, path+".migrated")
+}
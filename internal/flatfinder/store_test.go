@@ -0,0 +1,77 @@
+package flatfinder
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMigrateLegacyState(t *testing.T) {
+	tests := []struct {
+		name  string
+		state string
+		want  map[int64][]string
+	}{
+		{"shared properties", `{"properties": {"1": true, "2": true}}`, map[int64][]string{1: {"a", "b"}, 2: {"a", "b"}}},
+		{"per search", `{"searches": {"a": {"1": true}, "b": {"1": true, "3": true}}}`, map[int64][]string{1: {"a", "b"}, 3: {"b"}}},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		store, err := OpenStore(filepath.Join(dir, "flatfinder.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		path := filepath.Join(dir, "flatfinder.json")
+		err = os.WriteFile(path, []byte(test.state), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		logged := &bytes.Buffer{}
+		log.SetOutput(logged)
+		err = store.migrateLegacyState(path, []*Search{{Name: "a"}, {Name: "b"}})
+		log.SetOutput(os.Stderr)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		for id, want := range test.want {
+			stored, err := store.GetListing(id)
+			if err != nil || stored == nil {
+				t.Fatalf("%s: listing %d not migrated: %v", test.name, id, err)
+			}
+			got := []string{}
+			for name := range stored.Notifications {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: listing %d sent for %q, want %q", test.name, id, got, want)
+			}
+		}
+		if count := store.CountListings(); count != len(test.want) {
+			t.Errorf("%s: %d listings stored, want %d", test.name, count, len(test.want))
+		}
+
+		// Each ID counted once however many searches had it
+		if want := "Migrated 2 previously posted property IDs"; !strings.Contains(logged.String(), want) {
+			t.Errorf("%s: logged %q, want %q", test.name, logged, want)
+		}
+
+		// Moved aside so it only happens once
+		if fileExists(path) || !fileExists(path+".migrated") {
+			t.Errorf("%s: %s not renamed to .migrated", test.name, path)
+		}
+		err = store.migrateLegacyState(path, []*Search{{Name: "a"}})
+		if err != nil {
+			t.Errorf("%s: second migration: %s", test.name, err)
+		}
+	}
+}
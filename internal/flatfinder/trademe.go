@@ -1,16 +1,45 @@
 package flatfinder
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
-var TradeMeBaseURL = "https://api.trademe.co.nz/v1/Search/Property/Rental.json"
+// TradeMeEnvironment - Where the API and OAuth endpoints live
+type TradeMeEnvironment struct {
+	APIURL   string
+	OAuthURL string
+}
+
+// TradeMeEnvironments - Selected with trademe.environment, sandbox needs its own keys
+var TradeMeEnvironments = map[string]TradeMeEnvironment{
+	"production": {
+		APIURL:   "https://api.trademe.co.nz/v1",
+		OAuthURL: "https://secure.trademe.co.nz/Oauth",
+	},
+	"sandbox": {
+		APIURL:   "https://api.tmsandbox.co.nz/v1",
+		OAuthURL: "https://secure.tmsandbox.co.nz/Oauth",
+	},
+}
+
+// TradeMeSearchPath - Rental search, under the API URL
+var TradeMeSearchPath = "/Search/Property/Rental.json"
+
+// TradeMeListingPath - Listing details, takes a listing ID
+var TradeMeListingPath = "/Listings/%d.json"
+
+// TradeMePageSize - Rows per page, 500 is the most Trade Me allows
+var TradeMePageSize = 500
 
 // https://developer.trademe.co.nz/api-reference/search-methods/rental-search
 type TrademeResultSet struct {
@@ -104,83 +133,218 @@ type TradeMeListing struct {
 	Lounges         int    `json:"Lounges"`
 }
 
-func (c *LocalConfig) searchTrademe() error {
-	// Only pull last 2 hours by default
-	dateFrom := time.Now().Add(-time.Hour * 8)
+// searchTrademe - Fetch every page of results and handle the listings
+func (c *LocalConfig) searchTrademe(ctx context.Context, search *Search) error {
+	pollStarted := time.Now()
+	dateFrom, err := c.searchDateFrom(search, pollStarted)
+	if err != nil {
+		return err
+	}
 
 	// Set filters
 	queryParams := url.Values{}
 	queryParams.Add("photo_size", "FullSize")   // 670x502
 	queryParams.Add("sort_order", "Default")    // Standard order
 	queryParams.Add("return_metadata", "false") // Include search data
-	queryParams.Add("rows", "500")              // Total results
+	queryParams.Add("rows", strconv.Itoa(TradeMePageSize))
+
+	queryParams.Add("date_from", dateFrom.UTC().Format("2006-01-02T15:04"))
+	queryParams.Add("suburb", search.Suburbs)
+	queryParams.Add("property_type", search.PropertyTypes)
+	queryParams.Add("price_max", search.PriceMax)
+	queryParams.Add("bedrooms_min", search.BedroomsMin)
+	queryParams.Add("bedrooms_max", search.BedroomsMax)
+
+	listings := []TradeMeListing{}
+	seen := make(map[int64]bool)
+	totalCount := 0
+	pages := 0
+	capped := false
+	for page := 1; ; page++ {
+		if c.TradeMeMaxPages > 0 && page > c.TradeMeMaxPages {
+			log.Printf("[%s] Stopping at page cap of %d, %d listings not fetched. Raise TRADEME_MAX_PAGES to get them", search.Name, c.TradeMeMaxPages, totalCount-len(seen))
+			capped = true
+			break
+		}
+
+		queryParams.Set("page", strconv.Itoa(page))
+		resultSet, err := c.fetchTrademePage(ctx, queryParams)
+		if err != nil {
+			return err
+		}
+
+		pages++
+		totalCount = resultSet.TotalCount
+		listings = append(listings, resultSet.List...)
+		for _, listing := range resultSet.List {
+			seen[listing.ListingID] = true
+		}
+
+		// Stop when we have everything, or the API stops giving us more. Pages shift
+		// as listings come and go, so repeats don't count towards the total
+		if len(resultSet.List) == 0 || len(seen) >= totalCount {
+			break
+		}
+	}
+
+	log.Printf("[%s] Query complete. Pages: %d, Listings: %d/%d", search.Name, pages, len(seen), totalCount)
+	held := c.handleTrademeListings(ctx, search, listings)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// Moving on would skip what the cap left behind, so search the same window again next time
+	if capped {
+		return nil
+	}
+
+	// Same for listings held back by a failed lookup, so they're looked up again
+	if held > 0 {
+		log.Printf("[%s] %d listings held until lookups work, searching the same window next poll", search.Name, held)
+		return nil
+	}
+
+	// Next search carries on from here
+	return c.Store.SetLastPoll(search.Name, pollStarted)
+}
+
+// searchDateFrom - Carry on from the last poll, but never look back further than Since
+func (c *LocalConfig) searchDateFrom(search *Search, now time.Time) (time.Time, error) {
+	earliest := now.Add(-c.Since)
 
-	queryParams.Add("date_from", dateFrom.Format("2006-01-02T15:00"))
-	queryParams.Add("suburb", c.Suburbs)
-	queryParams.Add("property_type", c.PropertyTypes)
-	queryParams.Add("price_max", c.PriceMax)
-	queryParams.Add("bedrooms_min", c.BedroomsMin)
-	queryParams.Add("bedrooms_max", c.BedroomsMax)
+	lastPoll, err := c.Store.LastPoll(search.Name)
+	if err != nil {
+		return earliest, err
+	}
+
+	// First run, or we've been down longer than Since
+	dateFrom := lastPoll.Add(-c.PollOverlap)
+	if lastPoll.IsZero() || dateFrom.Before(earliest) {
+		return earliest, nil
+	}
+
+	return dateFrom, nil
+}
+
+// fetchTrademePage - Run a single page of the rental search
+func (c *LocalConfig) fetchTrademePage(ctx context.Context, queryParams url.Values) (TrademeResultSet, error) {
+	var resultSet TrademeResultSet
 
 	// Build HTTP request
-	client := http.Client{}
-	req, err := http.NewRequest("GET", TradeMeBaseURL, nil)
+	req, err := c.newTrademeRequest(ctx, c.TradeMeAPIURL+TradeMeSearchPath)
 	if err != nil {
-		return err
+		return resultSet, err
 	}
 
 	// Append our filters
 	req.URL.RawQuery = queryParams.Encode()
 
-	// Auth
-	req.Header.Set("Authorization", "OAuth oauth_consumer_key=\""+c.TradeMeKey+"\", oauth_signature_method=\"PLAINTEXT\", oauth_signature=\""+c.TradeMeSecret+"&\"")
-
-	req.Header.Set("Content-TypeContent-Type", "application/json")
-	req.Header.Set("User-Agent", "https://tinker.nz/idanoo/flat-finder")
-
 	// Do the request
-	resp, err := client.Do(req)
+	resp, err := c.trademeClient().Do(req)
 	if err != nil {
-		return err
+		return resultSet, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		bodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
+	if resp.StatusCode != http.StatusOK {
+		return resultSet, errors.New("Invalid response from API: " + resp.Status)
+	}
 
-		return c.handleTrademeResponse(bodyBytes)
-	} else {
-		return errors.New("Invalid response from API: " + resp.Status)
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resultSet, err
 	}
+
+	err = json.Unmarshal(bodyBytes, &resultSet)
+	return resultSet, err
 }
 
-func (c *LocalConfig) handleTrademeResponse(responseJson []byte) error {
-	var resultSet TrademeResultSet
-	err := json.Unmarshal(responseJson, &resultSet)
+// trademeClient - Signs every request with our OAuth credentials
+func (c *LocalConfig) trademeClient() *http.Client {
+	if c.tradeMeHTTP == nil {
+		c.tradeMeHTTP = newHTTPClientWithTransport("trademe", 30*time.Second, 3, &oauthTransport{
+			credentials: c.oauthCredentials(),
+			next:        http.DefaultTransport,
+		})
+	}
+
+	return c.tradeMeHTTP
+}
+
+// newTrademeRequest - GET request with our headers, auth is added when sent
+func (c *LocalConfig) newTrademeRequest(ctx context.Context, requestURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	log.Printf("Query complete. Listings: %d", resultSet.TotalCount)
-	for _, result := range resultSet.List {
-		c.parseTrademeListing(result)
+	req.Header.Set("Content-TypeContent-Type", "application/json")
+	req.Header.Set("User-Agent", "https://tinker.nz/idanoo/flat-finder")
+
+	return req, nil
+}
+
+// parseTrademeDate - Trade Me dates look like /Date(1514764800000)/
+func parseTrademeDate(date string) (time.Time, error) {
+	millis := strings.TrimSuffix(strings.TrimPrefix(date, "/Date("), ")/")
+
+	// Drop any timezone offset, the millis are already UTC
+	if i := strings.LastIndexAny(millis, "+-"); i > 0 {
+		millis = millis[:i]
+	}
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid Trade Me date: %s", date)
 	}
 
-	// Update config if succcess
-	c.storeConfig()
-	return nil
+	return time.UnixMilli(ms), nil
 }
 
-func (c *LocalConfig) parseTrademeListing(listing TradeMeListing) {
-	// Only send if we haven't before!
-	if _, ok := c.PostedProperties[listing.ListingID]; !ok {
-		// Send the message!
-		c.sendEmbeddedMessage(listing)
+// parseTrademeListing - Record the listing and work out if this search needs telling
+func (c *LocalConfig) parseTrademeListing(search *Search, listing TradeMeListing) *listingJob {
+	stored, err := c.Store.SeenListing(listing)
+	if err != nil {
+		log.Printf("[%s] %s", search.Name, err)
+		return nil
+	}
 
-		// Make sure we add the key in to the map so we don't send it again!
-		c.PostedProperties[listing.ListingID] = true
+	// Turned down by a rule, only look again if the price, rules or requirements change
+	rejection, ok := stored.Rejections[search.Name]
+	if ok && rejection.RentPerWeek == listing.RentPerWeek && rejection.Rules == c.filterFingerprint(search) {
+		return nil
 	}
+
+	// Only send new listings once, after that just watch the price
+	if stored.notifiedFor(search.Name) {
+		alert := c.checkPriceDrop(search, stored)
+		if alert == nil {
+			return nil
+		}
+
+		return newListingJob(stored, *alert, false)
+	}
+
+	// Same property under a new listing ID?
+	relisted := c.relistedFrom(search, listing)
+
+	// Another search (or the old listing) may have already looked this one up
+	if stored.EnrichedAt.IsZero() && relisted != nil && !relisted.EnrichedAt.IsZero() {
+		stored.copyEnrichment(relisted)
+	}
+
+	alert := EnrichedListing{Alert: AlertNew}
+	if relisted != nil {
+		log.Printf("[%s] Relisted: %s (was %d)", search.Name, listing.Title, relisted.Listing.ListingID)
+		alert.Alert = AlertRelisted
+		alert.PreviousListingID = relisted.Listing.ListingID
+		alert.PreviousRentPerWeek = relisted.Notifications[search.Name].RentPerWeek
+		alert.PreviousPriceDisplay = relisted.Notifications[search.Name].PriceDisplay
+		if alert.PreviousPriceDisplay == "" {
+			alert.PreviousPriceDisplay = relisted.Listing.PriceDisplay
+		}
+	} else {
+		log.Printf("[%s] New listing: %s", search.Name, listing.Title)
+	}
+
+	return newListingJob(stored, alert, stored.EnrichedAt.IsZero())
 }
@@ -0,0 +1,269 @@
+package flatfinder
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchTrademe(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, notifier := newTestConfig(t, fake)
+
+	realPageSize := TradeMePageSize
+	TradeMePageSize = 2
+	defer func() { TradeMePageSize = realPageSize }()
+
+	err := c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Page 2 repeats a listing from page 1, it's only sent once
+	want := []string{"Sunny two bedroom townhouse", "Character flat close to town", "Modern apartment with views"}
+	if got := notifier.sent(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("sent %q, want %q", got, want)
+	}
+
+	queries := fake.searchQueries()
+	if len(queries) != 2 {
+		t.Fatalf("%d search requests, want 2", len(queries))
+	}
+	for i, query := range queries {
+		if query.Get("suburb") != "47,52" || query.Get("price_max") != "700" || query.Get("rows") != "2" {
+			t.Errorf("request %d missing filters: %s", i, query.Encode())
+		}
+	}
+	if queries[1].Get("page") != "2" {
+		t.Errorf("second request was page %s", queries[1].Get("page"))
+	}
+
+	listing := notifier.listings[2]
+	if listing.RentPerWeek != 700 || listing.Agency.Name != "Harbour Property Management" || listing.GeographicLocation.Latitude != -41.2921 {
+		t.Errorf("listing not parsed: %+v", listing.TradeMeListing)
+	}
+	if listing.Alert != AlertNew {
+		t.Errorf("alert %v, want new", listing.Alert)
+	}
+
+	lastPoll, err := c.Store.LastPoll("test")
+	if err != nil || lastPoll.IsZero() {
+		t.Fatalf("last poll not recorded: %v %s", lastPoll, err)
+	}
+
+	// Next poll overlaps the last one and sends nothing new
+	err = c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := notifier.sent(); len(got) != 3 {
+		t.Fatalf("sent %d listings after second poll, want 3", len(got))
+	}
+
+	dateFrom := fake.searchQueries()[2].Get("date_from")
+	if want := lastPoll.Add(-c.PollOverlap).UTC().Format("2006-01-02T15:04"); dateFrom != want {
+		t.Errorf("date_from %s, want %s", dateFrom, want)
+	}
+}
+
+func TestSearchTrademePageCap(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, notifier := newTestConfig(t, fake)
+	c.TradeMeMaxPages = 1
+
+	realPageSize := TradeMePageSize
+	TradeMePageSize = 2
+	defer func() { TradeMePageSize = realPageSize }()
+
+	err := c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := notifier.sent(); len(got) != 2 {
+		t.Fatalf("sent %d listings, want 2", len(got))
+	}
+	if got := len(fake.searchQueries()); got != 1 {
+		t.Fatalf("%d search requests, want 1", got)
+	}
+
+	// The listing left on page 2 must still be in the next search's window
+	lastPoll, err := c.Store.LastPoll("test")
+	if err != nil || !lastPoll.IsZero() {
+		t.Fatalf("last poll moved on past unfetched listings: %v %v", lastPoll, err)
+	}
+
+	c.TradeMeMaxPages = 0
+	err = c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := notifier.sent(); len(got) != 3 {
+		t.Fatalf("sent %d listings once uncapped, want 3", len(got))
+	}
+	if lastPoll, _ := c.Store.LastPoll("test"); lastPoll.IsZero() {
+		t.Errorf("last poll not recorded once complete")
+	}
+}
+
+func TestSearchTrademeShortPages(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, notifier := newTestConfig(t, fake)
+
+	realPageSize := TradeMePageSize
+	TradeMePageSize = 2
+	defer func() { TradeMePageSize = realPageSize }()
+
+	// Claims more than it has, an empty page ends the search rather than the count
+	fake.edit("search_page1.json", `"TotalCount": 3`, `"TotalCount": 10`)
+	fake.edit("search_page2.json", `"TotalCount": 3`, `"TotalCount": 10`)
+
+	err := c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queries := fake.searchQueries()
+	if len(queries) != 3 || queries[2].Get("page") != "3" {
+		t.Fatalf("%d search requests, want pages 1 to 3", len(queries))
+	}
+	if got := notifier.sent(); len(got) != 3 {
+		t.Fatalf("sent %d listings, want 3", len(got))
+	}
+	if lastPoll, _ := c.Store.LastPoll("test"); lastPoll.IsZero() {
+		t.Errorf("last poll not recorded")
+	}
+}
+
+func TestSearchTrademeRepeatedPage(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, _ := newTestConfig(t, fake)
+
+	realPageSize := TradeMePageSize
+	TradeMePageSize = 2
+	defer func() { TradeMePageSize = realPageSize }()
+
+	// Listings shifted between requests, so page 2 only repeats what page 1 had
+	fake.edit("search_page2.json", "4211000103", "4211000101")
+
+	err := c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Four listings fetched but only two different ones, so it keeps going
+	queries := fake.searchQueries()
+	if len(queries) != 3 || queries[2].Get("page") != "3" {
+		t.Fatalf("%d search requests, want pages 1 to 3", len(queries))
+	}
+}
+
+func TestSearchTrademeUnsigned(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, _ := newTestConfig(t, fake)
+
+	// Bypass the signing client to check the fake really checks
+	resp, err := newHTTPClient("trademe", time.Second, 0).Get(c.TradeMeAPIURL + TradeMeSearchPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 401 {
+		t.Fatalf("unsigned request got %s", resp.Status)
+	}
+}
+
+func TestTrademeListingOpen(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, _ := newTestConfig(t, fake)
+
+	tests := []struct {
+		id   int64
+		open bool
+	}{
+		{4211000101, true},  // Still running
+		{4211000102, false}, // Ended
+		{4211000199, false}, // Withdrawn, 404
+	}
+	for _, test := range tests {
+		_, open, err := c.trademeListingOpen(context.Background(), test.id)
+		if err != nil {
+			t.Errorf("%d: %s", test.id, err)
+			continue
+		}
+		if open != test.open {
+			t.Errorf("%d: open %v, want %v", test.id, open, test.open)
+		}
+	}
+}
+
+func TestParseTrademeDate(t *testing.T) {
+	tests := []struct {
+		date string
+		want int64
+	}{
+		{"/Date(1514764800000)/", 1514764800000},
+		{"/Date(1514764800000+1300)/", 1514764800000},
+	}
+	for _, test := range tests {
+		got, err := parseTrademeDate(test.date)
+		if err != nil {
+			t.Errorf("%s: %s", test.date, err)
+			continue
+		}
+		if got.UnixMilli() != test.want {
+			t.Errorf("%s: got %d, want %d", test.date, got.UnixMilli(), test.want)
+		}
+	}
+
+	_, err := parseTrademeDate("yesterday")
+	if err == nil {
+		t.Error("invalid date parsed")
+	}
+}
+
+func TestFetchListingDetails(t *testing.T) {
+	fake := newFakeTrademe(t)
+	c, notifier := newTestConfig(t, fake)
+	c.FetchDetails = true
+
+	err := c.searchTrademe(context.Background(), c.Searches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listing := notifier.listings[0]
+	if listing.Details == nil || !strings.HasPrefix(listing.Details.Body, "Warm, dry townhouse") {
+		t.Fatalf("details not fetched: %+v", listing.Details)
+	}
+	if listing.IdealTenant != "Couple or small family" || listing.MaxTenants != 3 || listing.Amenities != "Cafes, supermarket, buses" || listing.BestContactTime != "Weekdays after 5pm" {
+		t.Errorf("attributes not applied: %q %d %q %q", listing.IdealTenant, listing.MaxTenants, listing.Amenities, listing.BestContactTime)
+	}
+	wantPhotos := []string{
+		"https://trademe.tmcdn.co.nz/photoserver/full/1900000001.jpg",
+		"https://trademe.tmcdn.co.nz/photoserver/tq/1900000011.jpg",
+	}
+	if !reflect.DeepEqual(listing.PhotoUrls, wantPhotos) {
+		t.Errorf("photos %q, want %q", listing.PhotoUrls, wantPhotos)
+	}
+	if got := listing.Details.openHomeTimes(); len(got) != 1 {
+		t.Errorf("open homes %q, want 1", got)
+	}
+
+	// Missing details don't hold the listing back
+	if len(notifier.sent()) != 3 || notifier.listings[2].Details != nil {
+		t.Fatalf("sent %q", notifier.sent())
+	}
+
+	// Cached, a new sighting doesn't fetch again
+	stored, err := c.Store.GetListing(4211000101)
+	if err != nil || stored.Details == nil {
+		t.Fatalf("details not stored: %v %s", stored, err)
+	}
+	c.fetchDetails(context.Background(), stored)
+	if got := fake.listingRequests("4211000101"); got != 1 {
+		t.Fatalf("details fetched %d times, want 1", got)
+	}
+}
@@ -0,0 +1,92 @@
+package flatfinder
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+var travelTimesBucket = []byte("travel_times")
+
+// DefaultTravelCacheTTL - How long a cached Google result is trusted
+var DefaultTravelCacheTTL = 30 * 24 * time.Hour
+
+// DefaultTravelCachePrecision - Decimal places of lat/long in the cache key, 3 is about 100m
+var DefaultTravelCachePrecision = 3
+
+// CachedTravelTime - A Distance Matrix result and when Google gave it to us
+type CachedTravelTime struct {
+	At         time.Time  `json:"at"`
+	TravelTime TravelTime `json:"travel_time"`
+}
+
+// travelCacheKey - Rounded origin, mode, arrival time and destination address. The address
+// rather than the name, so renaming a destination keeps its results and moving it doesn't
+func (c *LocalConfig) travelCacheKey(destination Destination, lat float64, long float64) string {
+	return fmt.Sprintf(
+		"%.*f,%.*f|%s|%s|%s",
+		c.TravelCachePrecision, lat,
+		c.TravelCachePrecision, long,
+		destination.travelMode(),
+		destination.ArrivalTime,
+		destination.Address,
+	)
+}
+
+// cachedTravelTime - The stored result for a key if it's newer than the TTL
+func (c *LocalConfig) cachedTravelTime(key string) (TravelTime, bool) {
+	if c.Cache == nil || c.TravelCacheTTL <= 0 {
+		return TravelTime{}, false
+	}
+
+	cached, err := c.Cache.GetTravelTime(key)
+	if err != nil {
+		log.Printf("Travel time cache: %s", err)
+		return TravelTime{}, false
+	}
+	if cached == nil || time.Since(cached.At) > c.TravelCacheTTL {
+		return TravelTime{}, false
+	}
+
+	return cached.TravelTime, true
+}
+
+// cacheTravelTime - Remember a result, failures are only logged
+func (c *LocalConfig) cacheTravelTime(key string, travelTime TravelTime) {
+	if c.Cache == nil || c.TravelCacheTTL <= 0 {
+		return
+	}
+
+	err := c.Cache.PutTravelTime(key, CachedTravelTime{At: time.Now(), TravelTime: travelTime})
+	if err != nil {
+		log.Printf("Travel time cache: %s", err)
+	}
+}
+
+// GetTravelTime - A cached result, nil if there isn't one
+func (c *Cache) GetTravelTime(key string) (*CachedTravelTime, error) {
+	var cached CachedTravelTime
+	found, err := c.getJSON(travelTimesBucket, key, &cached)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &cached, nil
+}
+
+// PutTravelTime - Store a result under its key
+func (c *Cache) PutTravelTime(key string, cached CachedTravelTime) error {
+	return c.putJSON(travelTimesBucket, key, cached)
+}
+
+// PruneTravelTimes - Drop results older than maxAge, returns how many went
+func (c *Cache) PruneTravelTimes(maxAge time.Duration) (int, error) {
+	return c.pruneJSON(travelTimesBucket, func(v []byte) time.Time {
+		var cached CachedTravelTime
+		if err := json.Unmarshal(v, &cached); err != nil {
+			return time.Time{}
+		}
+		return cached.At
+	}, maxAge)
+}
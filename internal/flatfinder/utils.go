@@ -1,77 +1,125 @@
 package flatfinder
 
 import (
-	"encoding/json"
 	"errors"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 )
 
-// storeConfig - Write current config to disk
-func (c *LocalConfig) storeConfig() {
-	configFilePath := getConfigFilePath()
-
-	json, err := json.Marshal(c)
+// getConfigDir - $XDG_CONFIG_HOME/flatfinder, or the platform equivalent
+func getConfigDir() string {
+	path, err := os.UserConfigDir()
 	if err != nil {
-		log.Fatal("Failed to JSONify config")
+		return ""
 	}
 
-	err = os.WriteFile(configFilePath, json, 0644)
+	return filepath.Join(path, "flatfinder")
+}
+
+// getDefaultStateDir - $XDG_STATE_HOME/flatfinder, ~/.local/state/flatfinder if unset
+func getDefaultStateDir() (string, error) {
+	// Keep using the working directory for installs from before XDG support
+	for _, legacy := range []string{"flatfinder.db", "flatfinder.json"} {
+		if fileExists(legacy) {
+			return ".", nil
+		}
+	}
+
+	if path := os.Getenv("XDG_STATE_HOME"); path != "" {
+		return filepath.Join(path, "flatfinder"), nil
+	}
+
+	// No XDG state dir outside Linux, the config dir is the closest thing
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return getConfigDir(), nil
+	}
+
+	home, err := os.UserHomeDir()
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
+
+	return filepath.Join(home, ".local", "state", "flatfinder"), nil
 }
 
-// loadConfig - Pull existing config (if exists)
-func (c *LocalConfig) loadConfig() {
-	configFilePath := getConfigFilePath()
-	if fileExists(configFilePath) {
-		data, err := os.ReadFile(configFilePath)
+// resolveStateDir - Use the given dir or the default, creating it if needed
+func resolveStateDir(stateDir string) (string, error) {
+	var err error
+	if stateDir == "" {
+		stateDir, err = getDefaultStateDir()
 		if err != nil {
-			log.Fatal(err)
+			return "", err
 		}
+	}
 
-		// Load it into global
-		err = json.Unmarshal(data, c)
+	err = os.MkdirAll(stateDir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	return stateDir, nil
+}
+
+// getDefaultCacheDir - $XDG_CACHE_HOME/flatfinder, or the platform equivalent
+func getDefaultCacheDir() (string, error) {
+	path, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(path, "flatfinder"), nil
+}
+
+// resolveCacheDir - Use the given dir or the default, creating it if needed
+func resolveCacheDir(cacheDir string) (string, error) {
+	var err error
+	if cacheDir == "" {
+		cacheDir, err = getDefaultCacheDir()
 		if err != nil {
-			maps := make(map[int64]bool)
-			c.PostedProperties = maps
-		} else {
-			log.Printf("Loaded %d previously posted property IDs", len(c.PostedProperties))
+			return "", err
 		}
-	} else {
-		// Create empty map for first run
-		maps := make(map[int64]bool)
-		c.PostedProperties = maps
 	}
+
+	err = os.MkdirAll(cacheDir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	return cacheDir, nil
+}
+
+// findFile - First of the paths that exists, blank if none do
+func findFile(paths ...string) string {
+	for _, path := range paths {
+		if path != "" && fileExists(path) {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// FindEnvFile - .env in the working directory, then the config dir
+func FindEnvFile() string {
+	return findFile(".env", filepath.Join(getConfigDir(), ".env"))
+}
+
+// storeFilePath - Returns a string of the listing database path
+func (c *LocalConfig) storeFilePath() string {
+	return filepath.Join(c.StateDir, "flatfinder.db")
 }
 
-// getConfigFilePath - Returns a string of the config file pathg
-func getConfigFilePath() string {
-	// path := ""
-	// switch runtime.GOOS {
-	// case "linux":
-	// 	if os.Getenv("XDG_CONFIG_HOME") != "" {
-	// 		path = os.Getenv("XDG_CONFIG_HOME")
-	// 	} else {
-	// 		path = filepath.Join(os.Getenv("HOME"), ".config")
-	// 	}
-	// case "windows":
-	// 	path = os.Getenv("APPDATA")
-	// case "darwin":
-	// 	path = os.Getenv("HOME") + "/Library/Application Support"
-	// default:
-	// 	log.Fatalf("Unsupported platform? %s", runtime.GOOS)
-	// }
-
-	// path = path + fmt.Sprintf("%c", os.PathSeparator) + "flatfinder"
-	// err := os.MkdirAll(path, os.ModePerm)
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-
-	// return path + fmt.Sprintf("%c", os.PathSeparator) + "flatfinder.json"
-	return "flatfinder.json"
+// cacheFilePath - Returns a string of the lookup cache database path
+func (c *LocalConfig) cacheFilePath() string {
+	return filepath.Join(c.CacheDir, "cache.db")
+}
+
+// legacyStateFilePath - Returns a string of the pre database state file path
+func (c *LocalConfig) legacyStateFilePath() string {
+	return filepath.Join(c.StateDir, "flatfinder.json")
 }
 
 // fileExists - Check if a file exists
@@ -86,3 +134,24 @@ func fileExists(filePath string) bool {
 
 	return false
 }
+
+// truncate - Cut s down to at most n runes, marking that it was cut
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
+
+// containsString - Is s one of list
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
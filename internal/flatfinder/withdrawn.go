@@ -0,0 +1,185 @@
+package flatfinder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// DefaultListingCheckEvery - How often sent listings are looked up for withdrawals and price changes
+var DefaultListingCheckEvery = 6 * time.Hour
+
+// listingChecksPerPoll - Most listings looked up each poll, the rest wait for the next one so a
+// backlog (like everything imported from flatfinder.json) doesn't hold up searching
+var listingChecksPerPoll = 50
+
+// checkListings - Look up listings we've sent, mark any that are gone and alert on any
+// that got cheaper. Search only finds listings by start date, so older ones are only seen here
+func (c *LocalConfig) checkListings(ctx context.Context) {
+	if c.WithdrawnCheckEvery == 0 {
+		return
+	}
+
+	listings, err := c.Store.OpenNotifiedListings()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	// Longest since checked first
+	due := []*StoredListing{}
+	for _, stored := range listings {
+		if time.Since(stored.LastCheckedAt) >= c.WithdrawnCheckEvery {
+			due = append(due, stored)
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].LastCheckedAt.Before(due[j].LastCheckedAt)
+	})
+	if len(due) > listingChecksPerPoll {
+		due = due[:listingChecksPerPoll]
+	}
+
+	closed := 0
+	for _, stored := range due {
+		if ctx.Err() != nil {
+			return
+		}
+
+		current, open, err := c.trademeListingOpen(ctx, stored.Listing.ListingID)
+		if err != nil {
+			log.Printf("Failed to check listing %d: %s", stored.Listing.ListingID, err)
+			continue
+		}
+
+		// Imported from flatfinder.json with only the ID, search has never seen it
+		seen := stored.Listing.Title != ""
+
+		stored.LastCheckedAt = time.Now()
+		if !open {
+			stored.ClosedAt = stored.LastCheckedAt
+			closed++
+			log.Printf("No longer available: %d %s", stored.Listing.ListingID, stored.Listing.Title)
+
+			// Nothing to say about a listing we don't have the details of
+			if c.NotifyWithdrawn && seen {
+				c.notifyWithdrawn(ctx, stored)
+			}
+		}
+
+		// Keep what Trade Me says now, a changed price is saved when it's handled below
+		var updated TradeMeListing
+		priceChanged := false
+		if open {
+			updated = updateListing(stored.Listing, current)
+			priceChanged = seen && stored.Listing.RentPerWeek != 0 && updated.RentPerWeek != stored.Listing.RentPerWeek
+
+			rentPerWeek, priceDisplay := stored.Listing.RentPerWeek, stored.Listing.PriceDisplay
+			stored.Listing = updated
+			if priceChanged {
+				stored.Listing.RentPerWeek, stored.Listing.PriceDisplay = rentPerWeek, priceDisplay
+			}
+		}
+
+		err = c.Store.PutListing(stored)
+		if err != nil {
+			log.Print(err)
+		}
+
+		if priceChanged {
+			c.checkListingPrice(ctx, stored, updated)
+		}
+	}
+
+	log.Printf("Listing check complete. Checked: %d/%d, Closed: %d", len(due), len(listings), closed)
+}
+
+// checkListingPrice - Run a changed price through each search that was sent the listing,
+// the same as if search had found it again
+func (c *LocalConfig) checkListingPrice(ctx context.Context, stored *StoredListing, listing TradeMeListing) {
+	for _, search := range c.Searches {
+		if stored.notifiedFor(search.Name) {
+			c.handleTrademeListings(ctx, search, []TradeMeListing{listing})
+		}
+	}
+}
+
+// updateListing - The listing as Trade Me has it now, details don't have everything
+// search returns (like the location) so anything missing is kept from before
+func updateListing(stored TradeMeListing, current TradeMeListing) TradeMeListing {
+	updated := reflect.ValueOf(&current).Elem()
+	previous := reflect.ValueOf(stored)
+	for i := 0; i < updated.NumField(); i++ {
+		if updated.Field(i).IsZero() {
+			updated.Field(i).Set(previous.Field(i))
+		}
+	}
+
+	return current
+}
+
+// trademeListingOpen - Is the listing still up on Trade Me, and what it says now if it is
+func (c *LocalConfig) trademeListingOpen(ctx context.Context, listingID int64) (TradeMeListing, bool, error) {
+	var listing TradeMeListing
+
+	req, err := c.newTrademeRequest(ctx, c.TradeMeAPIURL+fmt.Sprintf(TradeMeListingPath, listingID))
+	if err != nil {
+		return listing, false, err
+	}
+
+	resp, err := c.trademeClient().Do(req)
+	if err != nil {
+		return listing, false, err
+	}
+	defer resp.Body.Close()
+
+	// Withdrawn listings are removed entirely
+	if resp.StatusCode == http.StatusNotFound {
+		return listing, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return listing, false, errors.New("Invalid response from API: " + resp.Status)
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return listing, false, err
+	}
+
+	err = json.Unmarshal(bodyBytes, &listing)
+	if err != nil {
+		return listing, false, err
+	}
+
+	// Let or expired listings are still returned until Trade Me cleans them up
+	endDate, err := parseTrademeDate(listing.EndDate)
+	if err != nil {
+		return listing, false, err
+	}
+
+	return listing, endDate.After(time.Now()), nil
+}
+
+// notifyWithdrawn - Follow up with every search that was told about the listing
+func (c *LocalConfig) notifyWithdrawn(ctx context.Context, stored *StoredListing) {
+	enriched := stored.enriched()
+	enriched.Alert = AlertWithdrawn
+
+	for _, search := range c.Searches {
+		if stored.notifiedFor(search.Name) {
+			// Follow ups go wherever the listing went
+			enriched.LowPriority = stored.Notifications[search.Name].LowPriority
+			c.notify(ctx, search, enriched)
+		}
+	}
+}